@@ -0,0 +1,98 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/data"
+	"SrbastianM/rest-api-gin/internal/validator"
+	"net/http"
+)
+
+// Record a write operation done by the authenticated user (or by nobody, for anonymous
+// requests). before and after are the resource before and after the change, nil for a
+// creation or a deletion, and only the fields that changed are stored.
+func (app *application) audit(r *http.Request, action, resourceType string, resourceID int64, before, after interface{}) {
+	app.auditAs(r, app.contextGetUser(r).ID, action, resourceType, resourceID, before, after)
+}
+
+// Like audit() but for the requests where the user proves who is with a token or the
+// password instead of the Authorization header (login, activation...). actorID 0 means
+// nobody.
+//
+// The change is already done when this runs, so a failure is logged and the response
+// goes on as usual.
+func (app *application) auditAs(r *http.Request, actorID int64, action, resourceType string, resourceID int64, before, after interface{}) {
+	changes, err := data.AuditChanges(before, after)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	event := &data.AuditEvent{
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      changes,
+		ClientIP:     app.contextGetClientIP(r),
+		RequestID:    app.contextGetRequestID(r),
+	}
+	if actorID != 0 {
+		event.ActorID = &actorID
+	}
+
+	err = app.models.Audit.Insert(event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The fields of a user worth auditing, the password hash never goes in the audit log.
+func auditUser(user *data.User) envelop {
+	return envelop{"name": user.Name, "email": user.Email, "activated": user.Activated, "version": user.Version}
+}
+
+// The fields of a token worth auditing, never the plaintext.
+func auditToken(token *data.Token) envelop {
+	return envelop{"scope": token.Scope, "expiry": token.Expiry}
+}
+
+// List the audit events, filtered by actor, resource and time range. Only available with
+// the "users:admin" permission.
+func (app *application) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.ResourceType = app.readString(qs, "resource_type", "")
+	input.ResourceID = int64(app.readInt(qs, "resource_id", 0, v))
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.Schema = data.AuditSchema
+	input.Filters.Ranges = app.readRanges(qs, data.AuditSchema, v)
+
+	app.rejectUnknownParams(qs, v, append(data.AuditSchema.Params(), "actor_id", "resource_type", "resource_id", "page", "page_size", "sort")...)
+
+	v.Check(input.ActorID >= 0, "actor_id", "must be a positive integer")
+	v.Check(input.ResourceID >= 0, "resource_id", "must be a positive integer")
+	v.Check(input.ResourceID == 0 || input.ResourceType != "", "resource_id", "needs a resource_type")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/data"
+	"SrbastianM/rest-api-gin/internal/validator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// The most operations a bulk request can have, and the size of its body.
+const (
+	maxBulkOperations = 1000
+	maxBulkBytes      = 10_485_760
+)
+
+// One operation of a bulk request. The food has the same fields as the body of the create
+// and update endpoints, for an update only the fields sent change.
+type bulkFoodInput struct {
+	Action  string
+	ID      int64
+	Version *int32
+	Food    *struct {
+		Title       *string
+		Types       []string
+		Nutrition   *data.Nutrition
+		ServingSize *float64 `json:"serving_size"`
+	}
+}
+
+// The result of one operation, in the same order as the request. Status is the one the
+// single endpoint would have answered.
+type bulkFoodResult struct {
+	Index   int               `json:"index"`
+	Action  string            `json:"action"`
+	Status  int               `json:"status"`
+	ID      int64             `json:"id,omitempty"`
+	Version int32             `json:"version,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Create, update and delete many foods with one request, in a single transaction. With
+// mode=atomic (the default) nothing is written if any operation fails, with
+// mode=best_effort the operations that can be done are done. The response has the result
+// of every operation.
+func (app *application) bulkFoodsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	mode := app.readString(qs, "mode", "atomic")
+	v.Check(validator.In(mode, "atomic", "best_effort"), "mode", "must be atomic or best_effort")
+
+	app.rejectUnknownParams(qs, v, "mode")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	inputs, err := app.readBulkFoods(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	atomic := mode == "atomic"
+	results := make([]bulkFoodResult, len(inputs))
+	ops := make([]*data.FoodOperation, len(inputs))
+	befores := make([]*data.Food, len(inputs))
+	failed := false
+
+	// Check every operation first, the ones that can't be done don't go to the database.
+	for i, input := range inputs {
+		results[i] = bulkFoodResult{Index: i, Action: input.Action, ID: input.ID}
+		ops[i], befores[i] = app.prepareBulkFood(r, &results[i], input)
+		failed = failed || ops[i] == nil
+	}
+
+	var valid []*data.FoodOperation
+	for _, op := range ops {
+		if op != nil {
+			valid = append(valid, op)
+		}
+	}
+
+	if len(valid) > 0 && !(atomic && failed) {
+		err = app.models.Foods.Bulk(valid, app.contextGetUser(r).ID, atomic)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	for i, op := range ops {
+		if op == nil || op.Err == nil {
+			continue
+		}
+		// The food changed after it was read, for the operations with a version that's the
+		// same as a failed If-Match.
+		if errors.Is(op.Err, data.ErrEditConflict) && inputs[i].Version != nil {
+			setBulkFoodPreconditionFailed(&results[i])
+		} else {
+			app.setBulkFoodError(r, &results[i], op.Err)
+		}
+		failed = true
+	}
+
+	// In atomic mode one failure means nothing was written.
+	if atomic && failed {
+		for i, op := range ops {
+			if op != nil && op.Err == nil {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Error = "not applied because another operation failed"
+			}
+		}
+
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, envelop{"results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	applied := 0
+	for i, op := range ops {
+		if op == nil || op.Err != nil {
+			continue
+		}
+
+		applied++
+		results[i].ID = op.Food.ID
+		switch op.Action {
+		case data.BulkCreate:
+			results[i].Status = http.StatusCreated
+			results[i].Version = op.Food.Version
+			app.audit(r, "food.create", "food", op.Food.ID, nil, op.Food)
+		case data.BulkUpdate:
+			results[i].Status = http.StatusOK
+			results[i].Version = op.Food.Version
+			app.audit(r, "food.update", "food", op.Food.ID, befores[i], op.Food)
+		case data.BulkDelete:
+			results[i].Status = http.StatusOK
+			app.audit(r, "food.delete", "food", op.Food.ID, op.Food, nil)
+		}
+	}
+
+	// In best effort mode a client that only looks at the status must still see the
+	// failures: 207 if some operations failed and 422 (like atomic mode) if all of them did.
+	status := http.StatusOK
+	switch {
+	case applied == 0:
+		status = http.StatusUnprocessableEntity
+	case applied < len(ops):
+		status = http.StatusMultiStatus
+	}
+
+	err = app.writeJSON(w, status, envelop{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Read the operations of a bulk request, a JSON array or, with the application/x-ndjson
+// content type, one JSON object per line.
+func (app *application) readBulkFoods(w http.ResponseWriter, r *http.Request) ([]bulkFoodInput, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	ndjson := mediaType == "application/x-ndjson"
+
+	if !ndjson {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, jsonDecodeError(err, maxBulkBytes)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, errors.New("body must be a JSON array of operations")
+		}
+	}
+
+	var inputs []bulkFoodInput
+	for ndjson || dec.More() {
+		var input bulkFoodInput
+		err := dec.Decode(&input)
+		if ndjson && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", len(inputs), jsonDecodeError(err, maxBulkBytes))
+		}
+
+		inputs = append(inputs, input)
+		if len(inputs) > maxBulkOperations {
+			return nil, fmt.Errorf("body must not contain more than %d operations", maxBulkOperations)
+		}
+	}
+
+	if !ndjson {
+		if _, err := dec.Token(); err != nil {
+			return nil, jsonDecodeError(err, maxBulkBytes)
+		}
+	}
+
+	if len(inputs) == 0 {
+		return nil, errors.New("body must contain at least one operation")
+	}
+
+	return inputs, nil
+}
+
+// Turn one operation of the request into the operation for the database, reading the food
+// for the updates and deletes and validating it. If the operation can't be done it sets the
+// error in the result and returns nil. The food as it was is returned for the audit log.
+func (app *application) prepareBulkFood(r *http.Request, result *bulkFoodResult, input bulkFoodInput) (*data.FoodOperation, *data.Food) {
+	v := validator.New()
+	v.Check(validator.In(input.Action, data.BulkCreate, data.BulkUpdate, data.BulkDelete), "action", "must be create, update or delete")
+
+	switch input.Action {
+	case data.BulkCreate:
+		v.Check(input.ID == 0, "id", "must not be provided")
+		v.Check(input.Version == nil, "version", "must not be provided")
+		v.Check(input.Food != nil, "food", "must be provided")
+	case data.BulkUpdate:
+		v.Check(input.ID > 0, "id", "must be a positive integer")
+		v.Check(input.Food != nil, "food", "must be provided")
+	case data.BulkDelete:
+		v.Check(input.ID > 0, "id", "must be a positive integer")
+		v.Check(input.Food == nil, "food", "must not be provided")
+	}
+
+	if !v.Valid() {
+		result.Status = http.StatusUnprocessableEntity
+		result.Errors = v.Errors
+		return nil, nil
+	}
+
+	food := &data.Food{}
+	var before *data.Food
+
+	if input.Action != data.BulkCreate {
+		current, err := app.models.Foods.Get(input.ID)
+		if err != nil {
+			app.setBulkFoodError(r, result, err)
+			return nil, nil
+		}
+
+		// Like the If-Match header of the single endpoints.
+		if input.Version != nil && *input.Version != current.Version {
+			setBulkFoodPreconditionFailed(result)
+			return nil, nil
+		}
+
+		copied := *current
+		food, before = current, &copied
+	}
+
+	if input.Food != nil {
+		if input.Food.Title != nil {
+			food.Title = *input.Food.Title
+		}
+		if input.Food.Types != nil {
+			food.Types = input.Food.Types
+		}
+		if input.Food.Nutrition != nil {
+			food.Nutrition = *input.Food.Nutrition
+		}
+		if input.Food.ServingSize != nil {
+			food.ServingSize = *input.Food.ServingSize
+		}
+
+		if data.ValidateFood(v, food); !v.Valid() {
+			result.Status = http.StatusUnprocessableEntity
+			result.Errors = v.Errors
+			return nil, nil
+		}
+	}
+
+	return &data.FoodOperation{Action: input.Action, Food: food}, before
+}
+
+// Set the status and message of a failed operation, the same the single endpoints send.
+func (app *application) setBulkFoodError(r *http.Request, result *bulkFoodResult, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		result.Status = http.StatusNotFound
+		result.Error = "the requested resource could not found"
+	case errors.Is(err, data.ErrEditConflict):
+		result.Status = http.StatusConflict
+		result.Error = "unable to update the record due to an edit conflict, please try again"
+	default:
+		app.logError(r, err)
+		result.Status = http.StatusInternalServerError
+		result.Error = "The server encountered a problem and could not process your request"
+	}
+}
+
+// Set the error of an operation whose version doesn't match the food, like a failed If-Match.
+func setBulkFoodPreconditionFailed(result *bulkFoodResult) {
+	result.Status = http.StatusPreconditionFailed
+	result.Error = "the record has been modified since you fetched it, please fetch it again"
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Parse the trusted proxies, space separated CIDRs like "10.0.0.0/8 fd00::/8". A plain
+// address is a network of only that address.
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", field)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", field)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range app.config.proxies.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the IP of the client. Only a trusted proxy can tell who the client is, so the
+// forwarding headers are ignored unless the connection comes from one. The addresses of
+// the Forwarded (RFC 7239) or X-Forwarded-For header are walked from the right, every
+// proxy appends the address it got the request from, and the first one that isn't a
+// trusted proxy is the client. The left ones could be made up by the client itself.
+// X-Real-IP is only used if the proxy doesn't send the other headers.
+func (app *application) resolveClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !app.isTrustedProxy(remote.Unmap()) {
+		return host
+	}
+	remote = remote.Unmap()
+
+	var hops []string
+	switch {
+	case len(r.Header.Values("Forwarded")) > 0:
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case len(r.Header.Values("X-Forwarded-For")) > 0:
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	case r.Header.Get("X-Real-IP") != "":
+		hops = []string{r.Header.Get("X-Real-IP")}
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// "unknown", an obfuscated identifier or junk, the last proxy we trust is the
+			// best we know.
+			break
+		}
+
+		client = addr
+		if !app.isTrustedProxy(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// Return the for= values of the Forwarded headers, in order, like
+// `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`. The elements without a
+// for= parameter are returned as empty strings so they still count as a hop.
+func forwardedFor(values []string) []string {
+	var hops []string
+
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+					break
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// Parse one address of the forwarding headers. It can have a port and IPv6 addresses
+// come between brackets when they do (or always, in the Forwarded header).
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// The realIP() middleware resolves the IP of the client once and keeps it in the request
+// context, for the rate limiter, the access log and the audit records.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, app.contextSetClientIP(r, app.resolveClientIP(r)))
+	})
+}
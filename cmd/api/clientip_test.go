@@ -0,0 +1,237 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	app := &application{}
+	app.config.proxies.trusted = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ff::/48"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer",
+			remote:  "203.0.113.9:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}, "Forwarded": {"for=198.51.100.7"}, "X-Real-IP": {"198.51.100.7"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "untrusted IPv6 peer",
+			remote:  "[2001:db8::1]:443",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}},
+			want:    "2001:db8::1",
+		},
+		{
+			name:   "trusted peer without headers",
+			remote: "10.0.0.1:80",
+			want:   "10.0.0.1",
+		},
+		{
+			name:    "peer without a port",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "IPv4-mapped trusted peer",
+			remote:  "[::ffff:10.0.0.1]:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "trusted IPv6 peer",
+			remote:  "[2001:db8:ff::1]:443",
+			headers: map[string][]string{"X-Forwarded-For": {"2001:db8::5"}},
+			want:    "2001:db8::5",
+		},
+		{
+			name:    "spoofed leftmost entry",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "spoofed leftmost entry that looks like a proxy",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"10.9.9.9, 198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "chain of trusted proxies",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.7, 192.0.2.1, 10.0.0.3"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "chain split over several headers",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.7", "10.0.0.2"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "every hop is a trusted proxy",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"10.0.0.5, 10.0.0.2"}},
+			want:    "10.0.0.5",
+		},
+		{
+			name:    "X-Forwarded-For with a port",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7:5000"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "IPv4-mapped client",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"::ffff:198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "junk in X-Forwarded-For",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7, not-an-ip"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "Forwarded wins over X-Forwarded-For",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.7"}, "X-Forwarded-For": {"6.6.6.6"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "Forwarded chain of trusted proxies",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {"for=6.6.6.6, for=198.51.100.7;proto=https, for=10.0.0.2;by=10.0.0.1"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "Forwarded quoted IPv6 with a port",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}},
+			want:    "2001:db8:cafe::17",
+		},
+		{
+			name:    "Forwarded quoted IPv4 with a port",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {`For="198.51.100.7:4711"`}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "Forwarded unknown node",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.7, for=unknown"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "Forwarded obfuscated node behind a trusted proxy",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {`for="_hidden", for=10.0.0.2`}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "Forwarded element without for",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.7, proto=https"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "X-Real-IP",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Real-IP": {"198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "X-Real-IP ignored with X-Forwarded-For",
+			remote:  "10.0.0.1:80",
+			headers: map[string][]string{"X-Real-IP": {"6.6.6.6"}, "X-Forwarded-For": {"198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for key, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+
+			if got := app.resolveClientIP(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"one element", []string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, []string{"192.0.2.60"}},
+		{"several elements", []string{"for=192.0.2.43, for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{"several headers", []string{"for=192.0.2.43", "for=198.51.100.17"}, []string{"192.0.2.43", "198.51.100.17"}},
+		{"quoted IPv6", []string{`for="[2001:db8:cafe::17]:4711"`}, []string{"[2001:db8:cafe::17]:4711"}},
+		{"case insensitive", []string{"proto=https;For=192.0.2.60"}, []string{"192.0.2.60"}},
+		{"obfuscated and unknown", []string{`for="_gazonk", for=unknown`}, []string{"_gazonk", "unknown"}},
+		{"element without for", []string{"proto=https, for=192.0.2.60"}, []string{"", "192.0.2.60"}},
+		{"spaces around pairs", []string{" for=192.0.2.60 ; proto=http "}, []string{"192.0.2.60"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardedFor(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHop(t *testing.T) {
+	tests := []struct {
+		hop  string
+		want string
+		ok   bool
+	}{
+		{"192.0.2.60", "192.0.2.60", true},
+		{" 192.0.2.60 ", "192.0.2.60", true},
+		{"192.0.2.60:4711", "192.0.2.60", true},
+		{"2001:db8::17", "2001:db8::17", true},
+		{"[2001:db8::17]", "2001:db8::17", true},
+		{"[2001:db8::17]:4711", "2001:db8::17", true},
+		{"::ffff:192.0.2.60", "192.0.2.60", true},
+		{"[::ffff:192.0.2.60]:4711", "192.0.2.60", true},
+		{"unknown", "", false},
+		{"_hidden", "", false},
+		{"", "", false},
+		{"192.0.2.60:http", "", false},
+		{"300.0.2.60", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hop, func(t *testing.T) {
+			addr, ok := parseHop(tt.hop)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if ok && addr.String() != tt.want {
+				t.Errorf("got %q, want %q", addr, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"SrbastianM/rest-api-gin/internal/data"
 	"context"
+	"net"
 	"net/http"
 )
 
@@ -10,10 +11,22 @@ import (
 type contextKey string
 
 // Conevrt the string "user" to a contextKey type and assing it to the userContextKey constant.
-const userContextKey = contextKey("user")
+// Likewise for the plaintext authentication token used by the request.
+const (
+	userContextKey      = contextKey("user")
+	tokenContextKey     = contextKey("token")
+	requestIDContextKey = contextKey("request_id")
+	accessLogContextKey = contextKey("access_log")
+	clientIPContextKey  = contextKey("client_ip")
+)
 
 // Returning a new copy of the request with the provided User struct added to the context.
+// The user is also handed to the access log entry, if the request has one.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok {
+		entry.user = user
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -26,3 +39,49 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// Returning a new copy of the request with the plaintext authentication token added to the context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// Retrieves the plaintext authentication token from the request context. Anonymous
+// requests don't have one, so it returns an empty string.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// Returning a new copy of the request with the request ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// Retrieves the request ID from the request context, empty if the request didn't go
+// through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// Returning a new copy of the request with the client IP added to the context.
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// Retrieves the client IP resolved by the realIP middleware. Without it (the debug
+// listener, for example) it's the address of the connection.
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+	return ip
+}
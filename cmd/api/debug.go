@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// Return the debug handlers: the expvar stats at /debug/vars and the pprof profiles at
+// /debug/pprof/. The paths have to be those, pprof.Index() looks for the profile name
+// after "/debug/pprof/".
+func (app *application) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// The debug listener has no authentication at all, so it must only be reachable from the
+// machine itself.
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("the debug address %q must be bound to localhost", addr)
+	}
+	return nil
+}
+
+// Start the debug server on its own address. It runs until the process exits, the profiles
+// don't need a graceful shutdown.
+func (app *application) serveDebug() error {
+	err := checkLoopbackAddr(app.config.debug.addr)
+	if err != nil {
+		return err
+	}
+
+	// The metrics are served here too, without the token the API asks for.
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", app.debugHandler())
+	mux.Handle("/metrics", app.metrics.registry.Handler())
+
+	srv := &http.Server{
+		Addr:        app.config.debug.addr,
+		Handler:     mux,
+		IdleTimeout: time.Minute,
+		ReadTimeout: 10 * time.Second,
+		// The CPU profile and the trace take 30 seconds by default.
+		WriteTimeout: 2 * time.Minute,
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("Starting debug serve", map[string]string{
+		"addr": srv.Addr,
+	})
+
+	go func() {
+		err := srv.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.PrintError(err, nil)
+		}
+	}()
+
+	return nil
+}
@@ -3,28 +3,94 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 )
 
+// Base of the type URIs of the problem details, the error code goes at the end.
+const problemTypeBase = "https://foody.net/problems/"
+
 // the logError() method is a generic logging error message.
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
-// the errorResponse() method is a generic helper for sending JSON-formatted error
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelop{"error": message}
+// the errorResponse() method is a generic helper for sending JSON-formatted error. The code
+// is a short stable name of the error ("edit-conflict", "not-found"...) used in the type of
+// the problem details when the client asks for them.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) {
+	var (
+		env    envelop
+		header http.Header
+	)
+
+	if app.config.errors.problemJSON || acceptsProblemJSON(r) {
+		env = app.problemDetails(r, status, code, message)
+		header = http.Header{"Content-Type": {"application/problem+json"}}
+	} else {
+		env = envelop{"error": message}
+	}
 
 	// write a error if this happens and return 500 internal server status code
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, status, env, header)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// Build a RFC 7807 problem details object. The validation errors go to the "errors" member
+// as a list of field and message, any other message goes to "detail".
+func (app *application) problemDetails(r *http.Request, status int, code string, message interface{}) envelop {
+	problem := envelop{
+		"type":     problemTypeBase + code,
+		"code":     code,
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": r.URL.Path,
+	}
+
+	if id := app.contextGetRequestID(r); id != "" {
+		problem["request_id"] = id
+	}
+
+	switch message := message.(type) {
+	case map[string]string:
+		fields := make([]string, 0, len(message))
+		for field := range message {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		errs := make([]envelop, 0, len(fields))
+		for _, field := range fields {
+			errs = append(errs, envelop{"field": field, "message": message[field]})
+		}
+
+		problem["detail"] = "one or more fields are invalid"
+		problem["errors"] = errs
+	default:
+		problem["detail"] = message
+	}
+
+	return problem
+}
+
+// Report whether the Accept header of the request lists application/problem+json.
+func acceptsProblemJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		if strings.TrimSpace(mediaType) == "application/problem+json" {
+			return true
+		}
+	}
+	return false
+}
+
 // the serverErrorResponse() method is a generic helper for sending a message if the server
 // encountered an unexpected problem at runtime. Return a message and 500 internal server error
 // status code. The message is a JSON response that contain the generic error
@@ -32,7 +98,7 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 	app.logError(r, err)
 
 	message := "The server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, "server-error", message)
 }
 
 // the serverErrorResponse() method will used to send 404 status code and
@@ -40,7 +106,7 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 
 	message := "the requested resource could not found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, "not-found", message)
 }
 
 // the methodNotAllowedResponse() method will used to send 405 Method not allowed
@@ -48,50 +114,57 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request)
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, "method-not-allowed", message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, "bad-request", err.Error())
 }
 
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "failed-validation", errors)
 }
 
 // the method editConflictResponse() will used to send and 409 Conflict response.
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, "edit-conflict", message)
+}
+
+// the method preconditionFailedResponse() sends a 412 Precondition Failed when the If-Match
+// header doesn't match the current ETag of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "precondition-failed", message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate-limit-exceeded", message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid-credentials", message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid-authentication-token", message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "authentication-required", message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "inactive-account", message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "not-permitted", message)
 }
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
@@ -22,8 +23,10 @@ func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request
 	// Declare a anonyms struct to hold the information that expect to be in HTTP
 	// request body
 	var input struct {
-		Title string
-		Types []string
+		Title       string
+		Types       []string
+		Nutrition   data.Nutrition
+		ServingSize float64 `json:"serving_size"`
 	}
 
 	// Initialize a new json.Decoder() instance which reads from the request body
@@ -37,8 +40,10 @@ func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	food := &data.Food{
-		Title: input.Title,
-		Types: input.Types,
+		Title:       input.Title,
+		Types:       input.Types,
+		Nutrition:   input.Nutrition,
+		ServingSize: input.ServingSize,
 	}
 
 	v := validator.New()
@@ -50,12 +55,14 @@ func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request
 	// Call Insert() method in the food model, passing pointer to the validated movie struct.
 	// This wil create a record in the database and update the movie struct with the
 	// system-generated information.
-	err = app.models.Foods.Insert(food)
+	err = app.models.Foods.Insert(food, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.audit(r, "food.create", "food", food.ID, nil, food)
+
 	// Make an empty http.Header map and then use the Set() method to add a new location Header,
 	// interpoling the system-generated ID for the new food in the URL
 	headers := make(http.Header)
@@ -63,12 +70,10 @@ func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request
 
 	// Wrte a Json response with a 201 Created status code, the food data in the
 	// response body, and the location header
-	err = app.writeJSON(w, http.StatusCreated, envelop{"food": food}, headers)
+	err = app.writeJSONWithETag(w, r, http.StatusCreated, envelop{"food": food}, foodETag(food), headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-
-	fmt.Fprintf(w, "%+v\n", input)
 }
 
 func (app *application) showFoodHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,8 +97,9 @@ func (app *application) showFoodHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// encode the struct to JSON  and send it as the HTTP Response
-	// Create an instance of envelop to it to writeJSON()
-	err = app.writeJSON(w, http.StatusOK, envelop{"food": food}, nil)
+	// Create an instance of envelop to it to writeJSON(). The ETag is the version, so clients
+	// can revalidate with If-None-Match and get a 304 if the food didn't change.
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelop{"food": food}, foodETag(food), nil)
 	if err != nil {
 		app.logger.PrintError(err, nil)
 		app.serverErrorResponse(w, r, err)
@@ -118,17 +124,19 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if r.Header.Get("X-expected-Version") != "" {
-		if strconv.FormatInt(int64(food.Version), 32) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
-		}
+	if !app.checkFoodPreconditions(w, r, food) {
 		return
 	}
 
+	// Keep a copy of the food as it was, for the audit log.
+	before := *food
+
 	// Declare a struct to hold the expected data from client
 	var input struct {
-		Title *string
-		Types []string
+		Title       *string
+		Types       []string
+		Nutrition   *data.Nutrition
+		ServingSize *float64 `json:"serving_size"`
 	}
 	// Read the json request body data into the input struct
 	err = app.readJSON(w, r, &input)
@@ -147,6 +155,15 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 		food.Types = input.Types
 	}
 
+	// The nutrition profile is replaced as a whole, not nutrient by nutrient.
+	if input.Nutrition != nil {
+		food.Nutrition = *input.Nutrition
+	}
+
+	if input.ServingSize != nil {
+		food.ServingSize = *input.ServingSize
+	}
+
 	// Validate the updated food record, sending the client a 422 Unprocessable Entity
 	// response if any checks fails
 	v := validator.New()
@@ -158,7 +175,7 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 
 	// Pass the updated movie record to our new Update() method. Intercept any ErrEditConflict() error and
 	// call the new editConflictResponse() helper.
-	err = app.models.Foods.Update(food)
+	err = app.models.Foods.Update(food, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -168,8 +185,11 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	// Write the updated movie record in a JSON Response
-	err = app.writeJSON(w, http.StatusOK, envelop{"food": food}, nil)
+
+	app.audit(r, "food.update", "food", food.ID, before, food)
+
+	// Write the updated movie record in a JSON Response, with the ETag of the new version
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelop{"food": food}, foodETag(food), nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -183,19 +203,43 @@ func (app *application) deleteFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Fetch the food for the audit log. With If-Match (or X-Expected-Version) the food is
+	// only deleted if it didn't change since the client fetched it.
+	food, err := app.models.Foods.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkFoodPreconditions(w, r, food) {
+		return
+	}
+
 	// Delete the food from DB, sending 404 Not found response to the client if there isn't a
-	// matching record
-	err = app.models.Foods.Delete(id)
+	// matching record. The food must still be at the version read above, so an update that
+	// lands in between is not deleted without being seen.
+	err = app.models.Foods.Delete(id, food.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	app.audit(r, "food.delete", "food", food.ID, food, nil)
+
 	// Return 200 OK status code along with a success message
 	err = app.writeJSON(w, http.StatusOK, envelop{"message": "Food successfully deleted"}, nil)
 	if err != nil {
@@ -220,8 +264,24 @@ func (app *application) listFoodHandler(w http.ResponseWriter, r *http.Request)
 	// the default size to 20.
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// The sort accepts several keys, like sort=-created_at,title. The schema declares the
+	// sort keys and the range filters (protein_min=10&kcal_max=200, created_after, etc...)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "-id", "-title", "type"}
+	input.Filters.Schema = data.FoodSchema
+	input.Filters.Ranges = app.readRanges(qs, data.FoodSchema, v)
+
+	app.rejectUnknownParams(qs, v, append(data.FoodSchema.Params(), "title", "types", "page", "page_size", "sort", "cursor", "count")...)
+
+	// With the cursor parameter (empty for the first page) the list switches to keyset
+	// pagination. The cursor remembers the sort, so it doesn't have to be sent again.
+	if qs.Has("cursor") {
+		app.listFoodByCursor(w, r, qs, input.Title, input.Types, input.Filters, v)
+		return
+	}
+
+	// The offset pages always have the total, count would be silently ignored so it's an
+	// error (like page with cursor).
+	v.Check(!qs.Has("count"), "count", "must be used with cursor")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -234,8 +294,68 @@ func (app *application) listFoodHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelop{"foods": foods, "metada": metadata}, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelop{"foods": foods, "metada": metadata}, "", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Keyset version of listFoodHandler, the page comes after (or before) the row of the cursor
+// and the metadata has the cursors of the next and previous pages. Counting the total means
+// one more query, so clients walking through the whole list can skip it with count=false.
+func (app *application) listFoodByCursor(w http.ResponseWriter, r *http.Request, qs url.Values, title string, types []string, filters data.Filters, v *validator.Validator) {
+	v.Check(!qs.Has("page"), "page", "must not be used with cursor")
+	filters.Page = 1
+	filters.Count = app.readBool(qs, "count", true, v)
+
+	if s := qs.Get("cursor"); s != "" {
+		cursor, err := data.DecodeCursor(s, app.config.cursor.secret, data.FoodSchema)
+		if err != nil {
+			v.AddError("cursor", "must be a valid cursor")
+		} else {
+			filters.Cursor = cursor
+			if !qs.Has("sort") {
+				filters.Sort = cursor.Sort
+			}
+		}
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	foods, page, err := app.models.Foods.GetAllByCursor(title, types, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	metadata := page.Metadata(filters.PageSize, app.config.cursor.secret)
+
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelop{"foods": foods, "metada": metadata}, "", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The ETag of a food is its version, it changes on every update.
+func foodETag(food *data.Food) string {
+	return `"` + strconv.FormatInt(int64(food.Version), 10) + `"`
+}
+
+// Check the If-Match header (and the older X-Expected-Version one) against the current
+// version of the food. If they don't match it sends the error response and returns false.
+func (app *application) checkFoodPreconditions(w http.ResponseWriter, r *http.Request, food *data.Food) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, foodETag(food), true) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" && strconv.FormatInt(int64(food.Version), 10) != expected {
+		app.editConflictResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"SrbastianM/rest-api-gin/internal/data"
 	"SrbastianM/rest-api-gin/internal/validator"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +13,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -37,30 +41,111 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// Read the "version" URL parameter, like readIDParam().
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	version, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid parameter")
+	}
+
+	return int32(version), nil
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelop, header http.Header) error {
 	//Encode the data to JSON and return err if there was one
-	// Indent the terminal output putting a tab and no line prefix ("")
-	js, err := json.MarshalIndent(data, "", "\t")
+	js, err := encodeJSON(data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
+	writeBody(w, status, js, header)
+	return nil
+}
 
+func writeBody(w http.ResponseWriter, status int, js []byte, header http.Header) {
 	// We won't encored errors at this point so we can write any headers.
 	// we loop through the header map and add each header to the http.ResponseWriter header map.
 	// if the map isn't nil that means its OK. Go doesn't trow an error
 	for key, value := range header {
 		w.Header()[key] = value
 	}
-	// Add "Content-Type: application-json" header, them write the status code and JSON response
-	w.Header().Set("Content-Type", "application-json")
+	// Add "Content-Type: application-json" header (unless the caller set another one, like the
+	// problem details), them write the status code and JSON response
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application-json")
+	}
 	w.WriteHeader(status)
 	w.Write(js)
+}
+
+// writeJSONWithETag works like writeJSON() but also sends an ETag header. When etag is
+// empty a weak one is derived from the hash of the body, that's what the lists use because
+// they change with any food in them. If the If-None-Match header of a GET request matches
+// the ETag only a 304 Not Modified is sent, without body.
+func (app *application) writeJSONWithETag(w http.ResponseWriter, r *http.Request, status int, data envelop, etag string, header http.Header) error {
+	js, err := encodeJSON(data)
+	if err != nil {
+		return err
+	}
 
+	if etag == "" {
+		sum := sha256.Sum256(js)
+		etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("ETag", etag)
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Get("If-None-Match"), etag, false) {
+		for key, value := range header {
+			w.Header()[key] = value
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	writeBody(w, status, js, header)
 	return nil
 }
 
+// Indent the terminal output putting a tab and no line prefix ("")
+func encodeJSON(data envelop) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(js, '\n'), nil
+}
+
+// Report whether the list of entity tags of an If-Match or If-None-Match header contains
+// the etag. "*" matches anything. The strong comparison (for If-Match) never matches
+// weak tags, the weak one (for If-None-Match) ignores the W/ prefix.
+func etagMatches(header, etag string, strong bool) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strong {
+			if tag == etag && !strings.HasPrefix(tag, "W/") {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// use http.MaxBytesReader() to limit the size of the request body to 1MB
 	maxBytes := 1_048_576
@@ -72,51 +157,56 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	// if there is an error during the decoding, start the triage
 	err := dec.Decode(dst)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-
-		switch {
-		// use the errors.AS() function whether the error has the type *json.SyntaxError
-		// if it does, return a plain text error message which includes the location of the problem
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-			// If the Decode() return an io.ErrUnexpectedEOF error for syntax errors in the JSON
-			// it returns a generic error message
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-			// If the Decode() return an json.UnmarshalTypeError error for JSON value is the wrong type for the
-			// target destination
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type (at character %q)", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-			// If the request body is empty return a io.EOF error
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-		// If the JSON contains a field wich cannot be mapped to the target destination
-		//	then Decode() will now return an error message
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-
-		// If the request exceeds 1MB in size the decode will now fail
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger dan %d bytes", maxBytes)
-			// If pass a no nil pointer to Decode() catch and panic
-			// rather returning the error to the handler
-		case errors.As(err, &invalidUnmarshalError):
-			panic(err)
-
-		default:
-			return err
-		}
+		return jsonDecodeError(err, maxBytes)
 	}
 
 	return nil
 }
 
+// Turn the error of a json.Decoder into a message for the client.
+func jsonDecodeError(err error, maxBytes int) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+
+	switch {
+	// use the errors.AS() function whether the error has the type *json.SyntaxError
+	// if it does, return a plain text error message which includes the location of the problem
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		// If the Decode() return an io.ErrUnexpectedEOF error for syntax errors in the JSON
+		// it returns a generic error message
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+		// If the Decode() return an json.UnmarshalTypeError error for JSON value is the wrong type for the
+		// target destination
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type (at character %q)", unmarshalTypeError.Field)
+		}
+		return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		// If the request body is empty return a io.EOF error
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	// If the JSON contains a field wich cannot be mapped to the target destination
+	//	then Decode() will now return an error message
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
+		return fmt.Errorf("body contains unknown key %s", fieldName)
+
+	// If the request exceeds 1MB in size the decode will now fail
+	case err.Error() == "http: request body too large":
+		return fmt.Errorf("body must not be larger dan %d bytes", maxBytes)
+		// If pass a no nil pointer to Decode() catch and panic
+		// rather returning the error to the handler
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+
+	default:
+		return err
+	}
+}
+
 // This helper returns a string value from the query string, or the provided default value if
 // no matching key could be found.
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -135,7 +225,7 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	if csv == "" {
 		return defaultValue
 	}
-	return strings.Split(csv, "")
+	return strings.Split(csv, ",")
 }
 
 // This helper reads an string value from the query string and converts it to an integer before returning.
@@ -157,17 +247,142 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// Accepts an arbitrary function as a parameter and recover the error instead of panic the aplication
-func (app *application) background(fn func()) {
+// This helper reads a boolean value (true, false, 1, 0...) from the query string, falling
+// back to the default and recording an error in the Validator if it isn't a boolean.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// This helper reads an optional float from the query string. It returns nil if no matching key
+// could be found, and records an error message in the Validator if couldnt be converted to a number
+func (app *application) readFloat(qs url.Values, key string, v *validator.Validator) *float64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "Must be a number")
+		return nil
+	}
+
+	return &f
+}
+
+// This helper reads an optional time from the query string, either a RFC 3339 timestamp or a
+// date (2006-01-02). It returns nil if no matching key could be found, and records an error
+// message in the Validator if couldnt be parsed
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return &t
+		}
+	}
+
+	v.AddError(key, "Must be a RFC 3339 timestamp or a date (YYYY-MM-DD)")
+	return nil
+}
+
+// This helper reads the range filters declared in the schema (kcal_min, created_after, etc...)
+// converting each bound to the kind of its field
+func (app *application) readRanges(qs url.Values, schema data.Schema, v *validator.Validator) []data.Range {
+	var ranges []data.Range
+
+	read := func(key string, kind data.FieldKind) interface{} {
+		if key == "" {
+			return nil
+		}
+		switch kind {
+		case data.KindNumber:
+			if f := app.readFloat(qs, key, v); f != nil {
+				return *f
+			}
+		case data.KindTime:
+			if t := app.readTime(qs, key, v); t != nil {
+				return *t
+			}
+		case data.KindText:
+			if s := app.readString(qs, key, ""); s != "" {
+				return s
+			}
+		}
+		return nil
+	}
+
+	for _, field := range schema {
+		r := data.Range{
+			Field: field,
+			Min:   read(field.MinParam, field.Kind),
+			Max:   read(field.MaxParam, field.Kind),
+		}
+		if r.Min != nil || r.Max != nil {
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges
+}
+
+// This helper records an error message in the Validator for every key of the query string
+// that isn't in the known list, so a typo like "protien_min" is not silently ignored
+func (app *application) rejectUnknownParams(qs url.Values, v *validator.Validator, known ...string) {
+	for key := range qs {
+		if !validator.In(key, known...) {
+			v.AddError(key, "unknown query parameter")
+		}
+	}
+}
+
+// Accepts an arbitrary function as a parameter and recover the error instead of panic the aplication.
+// The error returned by the function (like a mail that couldn't be sent) is logged with the request ID.
+func (app *application) background(r *http.Request, fn func() error) {
+	// The request ID is read now, the goroutine may outlive the request.
+	properties := map[string]string{
+		"request_id": app.contextGetRequestID(r),
+	}
+
 	app.wg.Add(1)
+	app.metrics.background.Add(1)
 	go func() {
 		defer func() {
+			app.metrics.background.Add(-1)
 			app.wg.Done()
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
 		}()
-		fn()
+
+		app.runRecovered(properties, fn)
+	}()
+}
+
+// Run the function logging its error, or its panic, instead of crashing the aplication.
+func (app *application) runRecovered(properties map[string]string, fn func() error) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), properties)
+		}
 	}()
 
+	if err := fn(); err != nil {
+		app.logger.PrintError(err, properties)
+	}
 }
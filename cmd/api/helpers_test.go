@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		strong bool
+		want   bool
+	}{
+		{"empty header", "", `"3"`, true, false},
+		{"empty header weak", "", `"3"`, false, false},
+		{"same strong tag", `"3"`, `"3"`, true, true},
+		{"same strong tag weak comparison", `"3"`, `"3"`, false, true},
+		{"another tag", `"2"`, `"3"`, true, false},
+		{"another tag weak comparison", `"2"`, `"3"`, false, false},
+		{"unquoted tag", `3`, `"3"`, true, false},
+		{"weak header strong comparison", `W/"3"`, `"3"`, true, false},
+		{"weak header weak comparison", `W/"3"`, `"3"`, false, true},
+		{"weak etag strong comparison", `W/"abc"`, `W/"abc"`, true, false},
+		{"weak etag weak comparison", `W/"abc"`, `W/"abc"`, false, true},
+		{"strong header for a weak etag", `"abc"`, `W/"abc"`, false, true},
+		{"lowercase weak prefix", `w/"3"`, `"3"`, false, false},
+		{"star", `*`, `"3"`, true, true},
+		{"star weak comparison", `*`, `W/"abc"`, false, true},
+		{"star in a list", `"1", *`, `"3"`, true, true},
+		{"list with the tag", `"1", "2", "3"`, `"3"`, true, true},
+		{"list without spaces", `"1","3"`, `"3"`, true, true},
+		{"list with extra spaces", `  "1" ,   "3"  `, `"3"`, true, true},
+		{"list without the tag", `"1", "2"`, `"3"`, true, false},
+		{"list with the weak tag strong comparison", `"1", W/"3"`, `"3"`, true, false},
+		{"list with the weak tag weak comparison", `"1", W/"3"`, `"3"`, false, true},
+		{"list with empty elements", `, ,"3",`, `"3"`, true, true},
+		{"prefix of the tag", `"33"`, `"3"`, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.strong); got != tt.want {
+				t.Errorf("etagMatches(%q, %q, %v) = %v, want %v", tt.header, tt.etag, tt.strong, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/ratelimit"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// rateLimitRoute is a stricter (or looser) limit for a group of routes, like the login. The
+// method can be "*" for any method and a path ending with "*" matches every route
+// starting with it.
+type rateLimitRoute struct {
+	method string
+	path   string
+	limit  ratelimit.Limit
+}
+
+// By default the login gets 5 attempts and then one every 10 seconds, and the sign up 3
+// accounts and then one every 20 seconds.
+var defaultRouteLimits = []rateLimitRoute{
+	{method: http.MethodPost, path: "/v1/tokens/authentication", limit: ratelimit.Limit{RPS: 0.1, Burst: 5}},
+	{method: http.MethodPost, path: "/v1/users", limit: ratelimit.Limit{RPS: 0.05, Burst: 3}},
+}
+
+func (rl rateLimitRoute) matches(method, pattern string) bool {
+	if rl.method != "*" && rl.method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(rl.path, "*"); ok {
+		return strings.HasPrefix(pattern, prefix)
+	}
+	return rl.path == pattern
+}
+
+// Parse a limit like "0.5:10", the requests per second and the burst.
+func parseLimit(s string) (ratelimit.Limit, error) {
+	rps, burst, found := strings.Cut(s, ":")
+	if !found {
+		return ratelimit.Limit{}, fmt.Errorf("invalid limit %q, it must look like rps:burst", s)
+	}
+
+	var limit ratelimit.Limit
+	var err error
+
+	// NaN and Inf parse fine but aren't a rate.
+	limit.RPS, err = strconv.ParseFloat(rps, 64)
+	if err != nil || !(limit.RPS > 0) || math.IsInf(limit.RPS, 0) {
+		return ratelimit.Limit{}, fmt.Errorf("invalid rps in the limit %q", s)
+	}
+	limit.Burst, err = strconv.Atoi(burst)
+	if err != nil || limit.Burst <= 0 {
+		return ratelimit.Limit{}, fmt.Errorf("invalid burst in the limit %q", s)
+	}
+
+	return limit, nil
+}
+
+// Parse the route limits, space separated like "POST:/v1/users=0.05:3 *:/v1/admin/*=5:10".
+func parseRouteLimits(val string) ([]rateLimitRoute, error) {
+	var routes []rateLimitRoute
+
+	for _, field := range strings.Fields(val) {
+		route, limit, found := strings.Cut(field, "=")
+		method, path, ok := strings.Cut(route, ":")
+		if !found || !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid route limit %q, it must look like METHOD:/path=rps:burst", field)
+		}
+
+		l, err := parseLimit(limit)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, rateLimitRoute{method: strings.ToUpper(method), path: path, limit: l})
+	}
+
+	return routes, nil
+}
+
+// Parse the role limits, space separated like "editor=5:10 admin=20:40".
+func parseRoleLimits(val string) (map[string]ratelimit.Limit, error) {
+	roles := make(map[string]ratelimit.Limit)
+
+	for _, field := range strings.Fields(val) {
+		role, limit, found := strings.Cut(field, "=")
+		if !found || role == "" {
+			return nil, fmt.Errorf("invalid role limit %q, it must look like role=rps:burst", field)
+		}
+
+		l, err := parseLimit(limit)
+		if err != nil {
+			return nil, err
+		}
+
+		roles[role] = l
+	}
+
+	return roles, nil
+}
+
+// Return the key and the limit of the request. The authenticated users are limited by
+// their ID (so they keep their budget when they change of network) and the anonymous
+// ones by IP. The first route limit that matches wins and has its own budget, otherwise
+// it's the default limit or the most generous of the roles of the user.
+func (app *application) rateLimitPolicy(router *httprouter.Router, r *http.Request) (string, ratelimit.Limit, error) {
+	user := app.contextGetUser(r)
+
+	var key string
+	if user.IsAnnonymous() {
+		key = "ip:" + app.contextGetClientIP(r)
+	} else {
+		key = "user:" + strconv.FormatInt(user.ID, 10)
+	}
+
+	pattern := routePattern(router, r)
+	for _, route := range app.config.limiter.routes {
+		if route.matches(r.Method, pattern) {
+			return "route:" + route.method + ":" + route.path + ":" + key, route.limit, nil
+		}
+	}
+
+	limit := ratelimit.Limit{RPS: app.config.limiter.rps, Burst: app.config.limiter.burst}
+
+	if len(app.config.limiter.roles) > 0 && !user.IsAnnonymous() {
+		// It runs on every request, with -permissions-cache-ttl the roles come from the cache.
+		roles, err := app.models.Roles.GetAllForUser(user.ID)
+		if err != nil {
+			return "", ratelimit.Limit{}, err
+		}
+
+		found := false
+		for _, role := range roles {
+			l, ok := app.config.limiter.roles[role]
+			if ok && (!found || l.RPS > limit.RPS) {
+				limit = l
+				found = true
+			}
+		}
+	}
+
+	return key, limit, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/ratelimit"
+	"reflect"
+	"testing"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ratelimit.Limit
+		wantErr bool
+	}{
+		{spec: "0.5:10", want: ratelimit.Limit{RPS: 0.5, Burst: 10}},
+		{spec: "2:4", want: ratelimit.Limit{RPS: 2, Burst: 4}},
+		{spec: "1e-1:5", want: ratelimit.Limit{RPS: 0.1, Burst: 5}},
+		{spec: "", wantErr: true},
+		{spec: "5", wantErr: true},
+		{spec: ":", wantErr: true},
+		{spec: "5:", wantErr: true},
+		{spec: ":10", wantErr: true},
+		{spec: "five:10", wantErr: true},
+		{spec: "5:ten", wantErr: true},
+		{spec: "5:1.5", wantErr: true},
+		{spec: "0:10", wantErr: true},
+		{spec: "-1:10", wantErr: true},
+		{spec: "5:0", wantErr: true},
+		{spec: "5:-3", wantErr: true},
+		{spec: "NaN:10", wantErr: true},
+		{spec: "Inf:10", wantErr: true},
+		{spec: "5:10:20", wantErr: true},
+		{spec: " 5:10", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseLimit(tt.spec)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRouteLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []rateLimitRoute
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			spec: "",
+		},
+		{
+			name: "several routes",
+			spec: "POST:/v1/users=0.05:3  *:/v1/admin/*=5:10",
+			want: []rateLimitRoute{
+				{method: "POST", path: "/v1/users", limit: ratelimit.Limit{RPS: 0.05, Burst: 3}},
+				{method: "*", path: "/v1/admin/*", limit: ratelimit.Limit{RPS: 5, Burst: 10}},
+			},
+		},
+		{
+			name: "lowercase method",
+			spec: "post:/v1/foods/:id=1:2",
+			want: []rateLimitRoute{
+				{method: "POST", path: "/v1/foods/:id", limit: ratelimit.Limit{RPS: 1, Burst: 2}},
+			},
+		},
+		{name: "no limit", spec: "POST:/v1/users", wantErr: true},
+		{name: "empty limit", spec: "POST:/v1/users=", wantErr: true},
+		{name: "no method", spec: "/v1/users=1:2", wantErr: true},
+		{name: "empty method", spec: ":/v1/users=1:2", wantErr: true},
+		{name: "relative path", spec: "POST:v1/users=1:2", wantErr: true},
+		{name: "empty path", spec: "POST:=1:2", wantErr: true},
+		{name: "malformed limit", spec: "POST:/v1/users=1", wantErr: true},
+		{name: "zero burst", spec: "POST:/v1/users=1:0", wantErr: true},
+		{name: "one bad route among good ones", spec: "POST:/v1/users=1:2 GET:/v1/foods=x:2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRouteLimits(tt.spec)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitRouteMatches(t *testing.T) {
+	tests := []struct {
+		route   rateLimitRoute
+		method  string
+		pattern string
+		want    bool
+	}{
+		{rateLimitRoute{method: "POST", path: "/v1/users"}, "POST", "/v1/users", true},
+		{rateLimitRoute{method: "POST", path: "/v1/users"}, "GET", "/v1/users", false},
+		{rateLimitRoute{method: "POST", path: "/v1/users"}, "POST", "/v1/users/:id", false},
+		{rateLimitRoute{method: "*", path: "/v1/users"}, "DELETE", "/v1/users", true},
+		{rateLimitRoute{method: "*", path: "/v1/admin/*"}, "GET", "/v1/admin/users/:id", true},
+		{rateLimitRoute{method: "*", path: "/v1/admin/*"}, "GET", "/v1/admin", false},
+		{rateLimitRoute{method: "GET", path: "/v1/foods*"}, "GET", "/v1/foods/:id", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.route.method+" "+tt.route.path+" "+tt.method+" "+tt.pattern, func(t *testing.T) {
+			if got := tt.route.matches(tt.method, tt.pattern); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
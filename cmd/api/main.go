@@ -4,11 +4,19 @@ import (
 	"SrbastianM/rest-api-gin/internal/data"
 	"SrbastianM/rest-api-gin/internal/jsonlog"
 	"SrbastianM/rest-api-gin/internal/mailer"
+	"SrbastianM/rest-api-gin/internal/ratelimit"
+	"SrbastianM/rest-api-gin/internal/validator"
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
 	"log"
+	"net/netip"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,15 +34,20 @@ type config struct {
 	port int
 	env  string
 	db   struct {
+		backend       string
 		dsn           string
 		maxOpensConns int
 		maxIdleConns  int
 		maxIdleTime   string
+		migrate       bool
 	}
 	limiter struct {
-		rps    float64
-		burst  int
-		enable bool
+		rps     float64
+		burst   int
+		enable  bool
+		backend string
+		routes  []rateLimitRoute
+		roles   map[string]ratelimit.Limit
 	}
 	smtp struct {
 		host     string
@@ -43,17 +56,53 @@ type config struct {
 		password string
 		sender   string
 	}
+	roles struct {
+		defaults []string
+	}
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	cursor struct {
+		secret []byte
+	}
+	errors struct {
+		problemJSON bool
+	}
+	accessLog struct {
+		sample  float64
+		exclude []string
+	}
+	debug struct {
+		enable bool
+		addr   string
+	}
+	metrics struct {
+		auth bool
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	proxies struct {
+		trusted []netip.Prefix
+	}
+	trash struct {
+		retention time.Duration
+	}
 }
 
 // Define the struct to hold the dependencies for the HTTP handlers,
 // helpers and middleware
 // Contain: copy of the config struct and a logger (just for now)
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	limiter ratelimit.Limiter
+	metrics *appMetrics
+	wg      sync.WaitGroup
+	// Closed when the server starts shutting down, to stop the long running goroutines.
+	shutdown chan struct{}
 }
 
 func main() {
@@ -70,14 +119,30 @@ func main() {
 		log.Fatalf("Error loading the .env file: %s", err)
 	}
 	flag.StringVar(&cfg.db.dsn, "db-sn", os.Getenv("DB_DSN"), "PostgreSQL DSN")
+	flag.StringVar(&cfg.db.backend, "db-backend", "postgres", "Storage backend (postgres|memory)")
 
 	flag.IntVar(&cfg.db.maxOpensConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.BoolVar(&cfg.db.migrate, "db-migrate", false, "Apply pending database migrations on startup")
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximun request per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximun burst")
 	flag.BoolVar(&cfg.limiter.enable, "limiter-enable", true, "Enable rate limiter")
+	flag.StringVar(&cfg.limiter.backend, "limiter-backend", "memory", "Rate limiter backend (memory|postgres), postgres shares the limits between instances")
+
+	// Stricter limits for the routes open to brute force and spam, with a budget of their own.
+	cfg.limiter.routes = defaultRouteLimits
+	flag.Func("limiter-routes", "Rate limits of route groups (space separated METHOD:/path=rps:burst, default \"POST:/v1/tokens/authentication=0.1:5 POST:/v1/users=0.05:3\")", func(val string) error {
+		var err error
+		cfg.limiter.routes, err = parseRouteLimits(val)
+		return err
+	})
+	flag.Func("limiter-roles", "Rate limits of the users with a role, instead of -limiter-rps and -limiter-burst (space separated role=rps:burst)", func(val string) error {
+		var err error
+		cfg.limiter.roles, err = parseRoleLimits(val)
+		return err
+	})
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
@@ -85,29 +150,165 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "da68096b0dc8fd", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Foody <no-reply@foody.net>", "SMTP sender")
 
+	// Roles assigned to every new registration, "viewer" only gives the foods:read permission.
+	cfg.roles.defaults = []string{"viewer"}
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", time.Minute, "How long the user permissions and roles are cached (0 disables the cache)")
+	flag.Func("default-roles", "Roles for new users (space separated, default \"viewer\")", func(val string) error {
+		cfg.roles.defaults = strings.Fields(val)
+		return nil
+	})
+
+	// Access log, one line per request. The sample is the fraction of requests that get logged.
+	cfg.accessLog.exclude = []string{"/v1/healthcheck"}
+	flag.Float64Var(&cfg.accessLog.sample, "accesslog-sample", 1, "Fraction of the requests written to the access log (0 disables it, server errors are always logged)")
+	flag.Func("accesslog-exclude", "Paths never written to the access log (space separated, default \"/v1/healthcheck\")", func(val string) error {
+		cfg.accessLog.exclude = strings.Fields(val)
+		return nil
+	})
+
+	// Origins of the browser clients allowed by CORS, like "https://foody.net https://www.foody.net".
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated, \"*\" allows any origin without credentials)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	// Load balancers and proxies allowed to tell the client IP with the Forwarded,
+	// X-Forwarded-For or X-Real-IP headers. Nobody by default.
+	flag.Func("trusted-proxies", "Trusted proxies that forward the client IP (space separated CIDRs, like \"10.0.0.0/8\")", func(val string) error {
+		var err error
+		cfg.proxies.trusted, err = parseTrustedProxies(val)
+		return err
+	})
+
+	// Debug handlers (expvar and pprof), off by default.
+	flag.BoolVar(&cfg.debug.enable, "debug-enable", false, "Enable the expvar and pprof debug handlers")
+	flag.StringVar(&cfg.debug.addr, "debug-addr", "", "Serve the debug handlers on this localhost address (like 127.0.0.1:4001) instead of /v1/debug with the debug:read permission")
+
+	// The metrics on the API port need a token by default, turn it off if only a private
+	// network reaches the API (or the scraper can't send a token).
+	flag.BoolVar(&cfg.metrics.auth, "metrics-auth", true, "Require the debug:read permission for /metrics on the API port")
+
+	// Deleted foods stay in the trash for this long, then they are deleted for good.
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long the deleted foods stay in the trash before they are purged (0 keeps them forever)")
+
+	// Send every error as application/problem+json, not only to the clients that ask for it.
+	flag.BoolVar(&cfg.errors.problemJSON, "errors-problem-json", false, "Always send the errors as RFC 7807 problem details")
+
+	// Key to sign the pagination cursors. Without one a random key is used, so the cursors
+	// stop working after a restart and aren't shared between instances.
+	cursorSecret := flag.String("cursor-secret", os.Getenv("CURSOR_SECRET"), "Secret key to sign the pagination cursors")
+
 	flag.Parse()
 
+	cfg.cursor.secret = []byte(*cursorSecret)
+
 	//initialize new logger which writes messages to the standard out stream
 	// prefixed with the current date and time
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
-	db, err := openDB(cfg)
+	if len(cfg.cursor.secret) == 0 {
+		cfg.cursor.secret = make([]byte, 32)
+		_, err = rand.Read(cfg.cursor.secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("no cursor secret provided, using a random one", nil)
+	}
+
+	// instance of the aplication struct, contains config struct and the logger
+	app := &application{
+		config:   cfg,
+		logger:   logger,
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		limiter:  ratelimit.NewTokenBucket(),
+		metrics:  newMetrics(),
+		shutdown: make(chan struct{}),
+	}
+
+	if cfg.limiter.backend != "memory" && cfg.limiter.backend != "postgres" {
+		logger.PrintFatal(fmt.Errorf("unknown rate limiter backend %q", cfg.limiter.backend), nil)
+	}
+
+	// Pick the storage backend. The memory one doesn't need PostgreSQL at all and
+	// loses every record when the server stops, so is only useful for tests and demos.
+	switch cfg.db.backend {
+	case "memory":
+		if flag.Arg(0) == "migrate" {
+			logger.PrintFatal(errors.New("migrations need the postgres storage backend"), nil)
+		}
+		if cfg.limiter.backend == "postgres" {
+			logger.PrintFatal(errors.New("the postgres rate limiter needs the postgres storage backend"), nil)
+		}
+		app.models = data.NewMemoryModels()
+		logger.PrintInfo("using in-memory storage backend", nil)
+	case "postgres":
+		db, err := openDB(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		defer db.Close()
+
+		logger.PrintInfo("database connection pool established", nil)
+
+		// The "migrate" subcommand only touches the schema and exits, it never starts the server.
+		if flag.Arg(0) == "migrate" {
+			err = app.migrate(db, flag.Args()[1:])
+			if err != nil {
+				logger.PrintFatal(err, nil)
+			}
+			return
+		}
+
+		if cfg.db.migrate {
+			err = app.migrateOnStartup(db)
+			if err != nil {
+				logger.PrintFatal(err, nil)
+			}
+		}
+
+		app.models = data.NewModels(db)
+		app.metrics.registerDB(db)
+
+		if cfg.limiter.backend == "postgres" {
+			app.limiter.Close()
+			app.limiter = ratelimit.NewSlidingWindow(db, func(err error) {
+				logger.PrintError(err, nil)
+			})
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown storage backend %q", cfg.db.backend), nil)
+	}
+
+	// Cache the permissions resolved by requirePermission, so the reads don't hit the
+	// database on every request. The hits and misses are published with expvar.
+	if cfg.permissions.cacheTTL > 0 {
+		cache := data.NewPermissionCache(cfg.permissions.cacheTTL)
+		app.models = app.models.WithPermissionCache(cache)
+		expvar.Publish("permissions_cache", expvar.Func(func() interface{} {
+			return cache.Stats()
+		}))
+	}
+
+	err = app.checkDefaultRoles()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 
-	defer db.Close()
+	if cfg.trash.retention > 0 {
+		app.purgeTrash()
+	}
 
-	logger.PrintInfo("database connection pool established", nil)
-	// instance of the aplication struct, contains config struct and the logger
-	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+	if cfg.debug.enable && cfg.debug.addr != "" {
+		err = app.serveDebug()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 	}
 
 	err = app.serve()
+	// The server is down, stop the cleanup of the rate limiter too.
+	app.limiter.Close()
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
@@ -147,3 +348,24 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Return the sql.DB connection pool.
 	return db, nil
 }
+
+// Make sure every role in -default-roles exists, otherwise new users would silently end
+// up without permissions.
+func (app *application) checkDefaultRoles() error {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+
+	for _, name := range app.config.roles.defaults {
+		if !validator.In(name, names...) {
+			return fmt.Errorf("unknown default role %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/metrics"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// appMetrics holds the metrics served in the Prometheus format at /metrics.
+type appMetrics struct {
+	registry    *metrics.Registry
+	requests    *metrics.CounterVec
+	duration    *metrics.HistogramVec
+	inFlight    *metrics.Gauge
+	rateLimited *metrics.CounterVec
+	rateErrors  *metrics.CounterVec
+	mails       *metrics.CounterVec
+	background  atomic.Int64 // Goroutines started by app.background() still running
+}
+
+func newMetrics() *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:    registry,
+		requests:    registry.NewCounterVec("http_requests_total", "Number of HTTP requests by route, method and status.", "route", "method", "status"),
+		duration:    registry.NewHistogramVec("http_request_duration_seconds", "Latency of the HTTP requests by route, method and status.", metrics.DefBuckets, "route", "method", "status"),
+		inFlight:    registry.NewGauge("http_requests_in_flight", "Number of HTTP requests being served."),
+		rateLimited: registry.NewCounterVec("http_rate_limit_rejections_total", "Number of requests rejected by the rate limiter."),
+		rateErrors:  registry.NewCounterVec("http_rate_limit_errors_total", "Number of requests let through because the rate limiter failed."),
+		mails:       registry.NewCounterVec("mail_sent_total", "Number of emails sent by result (success or failure).", "result"),
+	}
+
+	registry.NewGaugeFunc("background_goroutines", "Number of background tasks (like sending emails) still running.", func() float64 {
+		return float64(m.background.Load())
+	})
+
+	return m
+}
+
+// Publish the stats of the connection pool, only the postgres backend has one.
+func (m *appMetrics) registerDB(db *sql.DB) {
+	stats := []struct {
+		name    string
+		help    string
+		counter bool
+		value   func(sql.DBStats) float64
+	}{
+		{"db_open_connections", "Number of established connections, in use and idle.", false, func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"db_in_use_connections", "Number of connections in use.", false, func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"db_idle_connections", "Number of idle connections.", false, func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"db_max_open_connections", "Maximum number of open connections.", false, func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"db_wait_count_total", "Number of connections waited for.", true, func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"db_wait_duration_seconds_total", "Time blocked waiting for a connection.", true, func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"db_max_idle_closed_total", "Connections closed due to the max idle connections.", true, func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"db_max_idle_time_closed_total", "Connections closed due to the max idle time.", true, func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"db_max_lifetime_closed_total", "Connections closed due to the max lifetime.", true, func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+
+	for _, stat := range stats {
+		value := func() float64 { return stat.value(db.Stats()) }
+		if stat.counter {
+			m.registry.NewCounterFunc(stat.name, stat.help, value)
+		} else {
+			m.registry.NewGaugeFunc(stat.name, stat.help, value)
+		}
+	}
+}
+
+// The collectMetrics() middleware counts the requests and their latency by route pattern
+// (not the real path, or every food id would be a new series) and status.
+func (app *application) collectMetrics(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		app.metrics.inFlight.Add(1)
+		defer func() {
+			app.metrics.inFlight.Add(-1)
+
+			route := routePattern(router, r)
+			if route == "" {
+				route = "unmatched"
+			}
+			method := metricsMethod(r.Method)
+			status := strconv.Itoa(rw.status)
+
+			app.metrics.requests.Inc(route, method, status)
+			app.metrics.duration.Observe(time.Since(start).Seconds(), route, method, status)
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// The method is sent by the client, any method outside the standard ones is counted as
+// OTHER so it can't create new series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// Send an email counting the successes and failures. It runs inside app.background(), so
+// the error is logged there.
+func (app *application) sendMail(recipient, templateFile string, data interface{}) error {
+	err := app.mailer.Send(recipient, templateFile, data)
+	if err != nil {
+		app.metrics.mails.Inc("failure")
+		return err
+	}
+
+	app.metrics.mails.Inc("success")
+	return nil
+}
@@ -2,18 +2,200 @@ package main
 
 import (
 	"SrbastianM/rest-api-gin/internal/data"
+	"SrbastianM/rest-api-gin/internal/ratelimit"
 	"SrbastianM/rest-api-gin/internal/validator"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"net"
+	"io"
+	mrand "math/rand"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/julienschmidt/httprouter"
 )
 
+// What the browser clients of the trusted origins can do. The preflight responses are
+// cached by the browser for corsMaxAge.
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, If-Match, If-None-Match, X-Expected-Version, X-Request-ID"
+	corsExposedHeaders = "ETag, Location, X-Request-ID, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After"
+	corsMaxAge         = 10 * time.Minute
+)
+
+// Request IDs sent by the clients (or a proxy in front of us) are reused if they look sane,
+// anything else is replaced so nobody can inject junk in the logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// The requestID() middleware gives every request an ID to correlate the log lines with it.
+// It's stored in the request context and sent back in the X-Request-ID header.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			id = app.newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// The source of the request IDs, and the counter of the fallback ones.
+var (
+	requestIDRand    io.Reader = rand.Reader
+	requestIDCounter atomic.Uint64
+)
+
+// Return a random request ID. If the random source fails (it really shouldn't) the ID is
+// made of the time and a counter, so the requests never share an ID.
+func (app *application) newRequestID() string {
+	b := make([]byte, 16)
+
+	_, err := io.ReadFull(requestIDRand, b)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(requestIDCounter.Add(1), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder wraps the http.ResponseWriter to remember the status code and the
+// number of bytes of the body, for the access log.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Let http.ResponseController reach the original writer (Flush, deadlines...).
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// accessLogEntry is shared through the request context so the inner middleware can tell
+// the access log who the user is, the outer one only sees its own copy of the request.
+type accessLogEntry struct {
+	user *data.User
+}
+
+// The logAccess() middleware writes one log line per request with the method, the route
+// pattern, status, latency, bytes, client IP, user and request ID. The excluded paths
+// (like the healthcheck) are never logged and the rest is sampled, except the server
+// errors that are always logged.
+func (app *application) logAccess(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if validator.In(r.URL.Path, app.config.accessLog.exclude...) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := &accessLogEntry{}
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
+
+		defer func() {
+			if rw.status < 500 && mrand.Float64() >= app.config.accessLog.sample {
+				return
+			}
+
+			userID := ""
+			if entry.user != nil && !entry.user.IsAnnonymous() {
+				userID = strconv.FormatInt(entry.user.ID, 10)
+			}
+
+			app.logger.PrintInfo("request", map[string]string{
+				"method":     r.Method,
+				"route":      routePattern(router, r),
+				"status":     strconv.Itoa(rw.status),
+				"latency_ms": strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+				"bytes":      strconv.Itoa(rw.bytes),
+				"client_ip":  app.contextGetClientIP(r),
+				"user_id":    userID,
+				"request_id": app.contextGetRequestID(r),
+			})
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Return the pattern of the route that matches the request, like /v1/foods/:id, so the
+// logs can be grouped by route and don't fill up with ids. httprouter doesn't tell which
+// route matched, so the values of the parameters are replaced back with their names.
+// Requests that don't match any route return an empty string.
+//
+// A value can also be in a static segment (/v1/foods/v1), so the segments are checked by
+// position: with another value in it the path still matches that parameter. In httprouter a
+// segment is either a parameter or static for every route, so the check is exact.
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	path := r.URL.Path
+	handle, params, _ := router.Lookup(r.Method, path)
+	if handle == nil {
+		return ""
+	}
+
+	// A catch-all parameter (/*item) is always the last one and takes the rest of the path,
+	// with the slash.
+	var catchAll httprouter.Param
+	if n := len(params); n > 0 && strings.HasPrefix(params[n-1].Value, "/") {
+		catchAll = params[n-1]
+		params = params[:n-1]
+		path = strings.TrimSuffix(path, catchAll.Value)
+	}
+
+	segments := strings.Split(path, "/")
+	next := 0
+	for i := 0; i < len(segments) && next < len(params); i++ {
+		if segments[i] != params[next].Value {
+			continue
+		}
+
+		probe := slices.Clone(segments)
+		probe[i] = ":"
+		_, probed, _ := router.Lookup(r.Method, strings.Join(probe, "/")+catchAll.Value)
+		if probed.ByName(params[next].Key) == ":" {
+			segments[i] = ":" + params[next].Key
+			next++
+		}
+	}
+
+	pattern := strings.Join(segments, "/")
+	if catchAll.Key != "" {
+		pattern += "/*" + catchAll.Key
+	}
+	return pattern
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	// Create a defeared function wich always be run in the event of a panic as Go
 	// unwinds the stack.
@@ -34,62 +216,80 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// The rateLimit() middleware asks the limiter (in memory or shared in PostgreSQL) if the
+// user or client IP has budget left for the route, and tells the client about it in the
+// RateLimit-* headers. It runs after authenticate() to know who the user is, see
+// rateLimitPolicy() for the keys and limits.
+func (app *application) rateLimit(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enable {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-	// Initialize a new rate limiter wich allows an average of 2 request per second,
-	// with a maximun of a 4 request in a single burst -> at the same time
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
+		key, limit, err := app.rateLimitPolicy(router, r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	}()
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract the clients IP address from the request
-		if app.config.limiter.enable {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			// Lock the mutex to prevent this code form being execuded concurrently.
-			mu.Lock()
-			// Check if the IP is already on the map, if doesnt then initialize a new rate
-			// limiter and add the IP and the limiter to the map
-			// Feat: Use the request-per-second and burst values from the config struct
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-			}
-			clients[ip].lastSeen = time.Now()
-			// Check if the IP address exists. If the request isn't allowed, ulock the mutext
-			// and return the method rateLimitExceededResponse() wich means that is to many request
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
-			mu.Unlock()
+		result, err := app.limiter.Allow(r.Context(), key, limit)
+		if err != nil {
+			app.rateLimiterFailed(r, err)
+			next.ServeHTTP(w, r)
+			return
 		}
+
+		if !app.applyRateLimit(w, r, result) {
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Don't take the API down with the limiter (the database may be struggling), the request
+// goes on. The failure is logged and counted, a lot of them means nothing is limited.
+func (app *application) rateLimiterFailed(r *http.Request, err error) {
+	app.logError(r, err)
+	app.metrics.rateErrors.Inc()
+}
+
+// Set the RateLimit-* headers of the result and, if the request is over the limit, send
+// the 429. It returns false when the request must stop there.
+func (app *application) applyRateLimit(w http.ResponseWriter, r *http.Request, result ratelimit.Result) bool {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ratelimit.Seconds(result.Reset)))
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(ratelimit.Seconds(result.RetryAfter), 1)))
+		app.metrics.rateLimited.Inc()
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// The requests with a bad token never get to rateLimit, so they are counted here against
+// the budget of their IP (the same the anonymous requests use). Otherwise anyone could try
+// tokens as fast as they want. Once the budget is spent they get a 429 instead of the 401.
+func (app *application) rejectInvalidToken(w http.ResponseWriter, r *http.Request) {
+	if app.config.limiter.enable {
+		limit := ratelimit.Limit{RPS: app.config.limiter.rps, Burst: app.config.limiter.burst}
+
+		result, err := app.limiter.Allow(r.Context(), "ip:"+app.contextGetClientIP(r), limit)
+		if err != nil {
+			app.rateLimiterFailed(r, err)
+		} else if !app.applyRateLimit(w, r, result) {
+			return
+		}
+	}
+
+	app.invalidAuthenticationTokenResponse(w, r)
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -103,7 +303,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+			app.rejectInvalidToken(w, r)
 			return
 		}
 
@@ -111,21 +311,39 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
+			app.rejectInvalidToken(w, r)
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeActivation, token)
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				app.rejectInvalidToken(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
 			return
 		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The trackTokenUse() middleware keeps track of when the session of the request was last
+// used. It runs after rateLimit(), so the requests over the limit don't write to the
+// database. It's only bookkeeping so a failure is logged but doesn't stop the request.
+func (app *application) trackTokenUse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := app.contextGetToken(r); token != "" {
+			err := app.models.Token.UpdateLastUsed(token)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -189,9 +407,46 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivedUser(fn)
 }
 
+// The enableCORS() middleware lets the browser clients of the trusted origins call the API.
+// The origin is echoed back only if it's in the trusted list (so the responses vary by
+// Origin) and those origins can send credentials too. A "*" in the list allows any origin,
+// but without credentials.
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			allowed := false
+
+			switch {
+			case validator.In(origin, app.config.cors.trustedOrigins...):
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+				allowed = true
+			case validator.In("*", app.config.cors.trustedOrigins...):
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				allowed = true
+			}
+
+			if allowed {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+				// A preflight is an OPTIONS request with the Access-Control-Request-Method
+				// header, answer it here and don't go any further (it has no credentials, so
+				// it would fail the authentication).
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+					w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
 
 		next.ServeHTTP(w, r)
 	})
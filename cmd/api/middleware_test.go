@@ -0,0 +1,88 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/jsonlog"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRoutePattern(t *testing.T) {
+	router := httprouter.New()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	for _, path := range []string{
+		"/v1/healthcheck",
+		"/v1/foods/:id",
+		"/v1/foods/:id/revisions/:version",
+		"/v1/users/:id/roles/:role",
+		"/v1/debug/*item",
+	} {
+		router.HandlerFunc(http.MethodGet, path, noop)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"static", "/v1/healthcheck", "/v1/healthcheck"},
+		{"parameter", "/v1/foods/5", "/v1/foods/:id"},
+		{"value equal to an earlier static segment", "/v1/foods/v1", "/v1/foods/:id"},
+		{"value equal to its own static prefix", "/v1/foods/foods", "/v1/foods/:id"},
+		{"repeated value", "/v1/foods/1/revisions/1", "/v1/foods/:id/revisions/:version"},
+		{"repeated value equal to a static segment", "/v1/foods/revisions/revisions/revisions", "/v1/foods/:id/revisions/:version"},
+		{"repeated value in another route", "/v1/users/roles/roles/roles", "/v1/users/:id/roles/:role"},
+		{"catch-all", "/v1/debug/pprof/heap", "/v1/debug/*item"},
+		{"catch-all with a repeated value", "/v1/debug/debug", "/v1/debug/*item"},
+		{"no route", "/v1/nope", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if got := routePattern(router, r); got != tt.want {
+				t.Errorf("routePattern(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("no entropy") }
+
+func TestNewRequestID(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelError)}
+
+	tests := []struct {
+		name   string
+		source io.Reader
+	}{
+		{"random", rand.Reader},
+		{"random source fails", failingReader{}},
+		{"random source too short", strings.NewReader("short")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(r io.Reader) { requestIDRand = r }(requestIDRand)
+			requestIDRand = tt.source
+
+			first, second := app.newRequestID(), app.newRequestID()
+			if first == second {
+				t.Errorf("got the same ID twice: %q", first)
+			}
+			for _, id := range []string{first, second} {
+				if !requestIDRX.MatchString(id) {
+					t.Errorf("got %q, not a valid request ID", id)
+				}
+			}
+		})
+	}
+}
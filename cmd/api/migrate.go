@@ -0,0 +1,119 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/migrations"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: api migrate up|down [N]|status|goto N"
+
+// Handle the "migrate" subcommand: api [flags] migrate up|down [N]|status|goto N
+func (app *application) migrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		// Roll back only the last migration unless told otherwise, rolling back everything
+		// must be explicit with "goto 0".
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		err = migrator.Down(n)
+	case "goto":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		var version int64
+		version, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+		err = migrator.Goto(version)
+	case "status":
+		return app.printMigrationStatus(migrator)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil && !errors.Is(err, migrations.ErrNoChange) {
+		return err
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("database migrations completed", map[string]string{
+		"command": args[0],
+		"version": strconv.FormatInt(currentVersion(statuses), 10),
+	})
+	return nil
+}
+
+// Run the pending migrations when the server starts (-db-migrate flag).
+func (app *application) migrateOnStartup(db *sql.DB) error {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	err = migrator.Up()
+	if err != nil && !errors.Is(err, migrations.ErrNoChange) {
+		return err
+	}
+
+	app.logger.PrintInfo("database schema is up to date", map[string]string{
+		"version": strconv.FormatInt(migrator.Latest(), 10),
+	})
+	return nil
+}
+
+func (app *application) printMigrationStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.Applied() {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+
+	return tw.Flush()
+}
+
+// The current version is the highest applied one.
+func currentVersion(statuses []migrations.Status) int64 {
+	var version int64
+	for _, s := range statuses {
+		if s.Applied() && s.Version > version {
+			version = s.Version
+		}
+	}
+	return version
+}
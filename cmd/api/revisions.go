@@ -0,0 +1,164 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/data"
+	"SrbastianM/rest-api-gin/internal/validator"
+	"errors"
+	"net/http"
+)
+
+// List the revisions of a food, newest first, with who made each one and what changed.
+func (app *application) listFoodRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	food, ok := app.readFoodParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.Schema = data.RevisionSchema
+
+	app.rejectUnknownParams(qs, v, "page", "page_size", "sort")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Foods.GetRevisions(food.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the food as it was at one version.
+func (app *application) showFoodRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	revision, ok := app.readRevisionParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelop{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Restore a food as it was at one version. The old values are saved as a new version, so
+// the restore is in the history too and can be undone. It goes through Update(), so it
+// fails with an edit conflict if the food changes meanwhile, and accepts If-Match like
+// the updates.
+func (app *application) restoreFoodRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	food, ok := app.readFoodParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkFoodPreconditions(w, r, food) {
+		return
+	}
+
+	revision, ok := app.readRevisionParams(w, r)
+	if !ok {
+		return
+	}
+
+	before := *food
+
+	food.Title = revision.Food.Title
+	food.Types = revision.Food.Types
+	food.Nutrition = revision.Food.Nutrition
+	food.ServingSize = revision.Food.ServingSize
+
+	// The rules may have changed since the revision was saved.
+	v := validator.New()
+
+	if data.ValidateFood(v, food); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Foods.Update(food, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "food.restore", "food", food.ID, before, food)
+
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelop{"food": food}, foodETag(food), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Fetch the food of the "id" URL parameter. If it fails the response is already sent
+// and it returns false.
+func (app *application) readFoodParam(w http.ResponseWriter, r *http.Request) (*data.Food, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	food, err := app.models.Foods.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return food, true
+}
+
+// Fetch the revision of the "id" and "version" URL parameters. If it fails the response
+// is already sent and it returns false.
+func (app *application) readRevisionParams(w http.ResponseWriter, r *http.Request) (*data.FoodRevision, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	revision, err := app.models.Foods.GetRevision(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return revision, true
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -24,15 +23,70 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPost, "/v1/foods", app.requirePermission("foods:write", app.createFoodHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/foods", app.requirePermission("foods:read", app.listFoodHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/foods/:id", app.requirePermission("foods:read", app.showFoodHandler))
+	// httprouter can't have /v1/foods/trash or /v1/foods/bulk next to /v1/foods/:id, so they
+	// are served by the same routes when the id is "trash" or "bulk".
+	showFood := app.requirePermission("foods:read", app.showFoodHandler)
+	listFoodTrash := app.requirePermission("foods:write", app.listFoodTrashHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/foods/:id", func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "trash" {
+			listFoodTrash(w, r)
+			return
+		}
+		showFood(w, r)
+	})
+	bulkFoods := app.requirePermission("foods:write", app.bulkFoodsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/foods/:id", func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "bulk" {
+			bulkFoods(w, r)
+			return
+		}
+		app.methodNotAllowedResponse(w, r)
+	})
 	router.HandlerFunc(http.MethodPatch, "/v1/foods/:id", app.requirePermission("foods:write", app.updateFoodHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/foods/:id", app.requirePermission("foods:write", app.deleteFoodHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/foods/:id/restore", app.requirePermission("foods:write", app.restoreFoodHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/foods/:id/revisions", app.requirePermission("foods:read", app.listFoodRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/foods/:id/revisions/:version", app.requirePermission("foods:read", app.showFoodRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/foods/:id/revisions/:version/restore", app.requirePermission("foods:write", app.restoreFoodRevisionHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/tokens", app.requireAuthenticatedUser(app.listAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:admin", app.updateUserActivatedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.assignUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.removeUserRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.deleteUserTokensHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("users:admin", app.listAuditHandler))
 
-	router.Handler(http.MethodGet, "/v1/debug/vars", expvar.Handler())
+	// The debug handlers (expvar and pprof) leak a lot of internals, they are only mounted if
+	// enabled and need the debug:read permission. With a debug address they are served on
+	// their own localhost listener instead, see serveDebug().
+	if app.config.debug.enable && app.config.debug.addr == "" {
+		debug := http.StripPrefix("/v1", app.debugHandler())
+		router.HandlerFunc(http.MethodGet, "/v1/debug/*item", app.requirePermission("debug:read", debug.ServeHTTP))
+	}
+	// The metrics show the routes, the traffic and the database pool, so they are guarded
+	// the same way: the debug:read permission (unless -metrics-auth=false), or only the
+	// localhost debug listener.
+	if !app.config.debug.enable || app.config.debug.addr == "" {
+		metrics := app.metrics.registry.Handler().ServeHTTP
+		if app.config.metrics.auth {
+			metrics = app.requirePermission("debug:read", metrics)
+		}
+		router.HandlerFunc(http.MethodGet, "/metrics", metrics)
+	}
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+	return app.requestID(app.realIP(app.logAccess(router, app.collectMetrics(router, app.recoverPanic(app.enableCORS(app.authenticate(app.rateLimit(router, app.trackTokenUse(router)))))))))
 }
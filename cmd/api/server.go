@@ -32,6 +32,7 @@ func (app *application) serve() error {
 		app.logger.PrintInfo("shutting down server", map[string]string{
 			"signal": s.String(),
 		})
+		close(app.shutdown)
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
@@ -3,6 +3,8 @@ package main
 import (
 	"SrbastianM/rest-api-gin/internal/data"
 	"SrbastianM/rest-api-gin/internal/validator"
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"net/http"
 	"time"
@@ -52,12 +54,14 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.models.Token.New(user.ID, 24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.auditAs(r, user.ID, "token.create", "user", user.ID, nil, auditToken(token))
+
 	err = app.writeJSON(w, http.StatusCreated, envelop{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -65,3 +69,209 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 }
+
+// Send a new activation token to a user who lost the welcome email or let the token expire.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Rotate the activation tokens, only the one in the new email must work.
+	err = app.models.Token.DeleteAllFromUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "token.create", "user", user.ID, nil, auditToken(token))
+
+	app.background(r, func() error {
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+		}
+
+		return app.sendMail(user.Email, "token_activation.tmpl", data)
+	})
+
+	env := envelop{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only activated accounts can reset the password, otherwise the reset would be a way
+	// to skip the activation email.
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Token.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "token.create", "user", user.ID, nil, auditToken(token))
+
+	app.background(r, func() error {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		return app.sendMail(user.Email, "token_password_reset.tmpl", data)
+	})
+
+	env := envelop{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the active sessions (authentication tokens) of the current user. The plaintext
+// tokens are never stored, so the session used by the request is flagged as "current".
+func (app *application) listAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	tokens, err := app.models.Token.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	currentHash := sha256.Sum256([]byte(app.contextGetToken(r)))
+
+	type session struct {
+		CreatedAt  time.Time  `json:"created_at"`
+		Expiry     time.Time  `json:"expiry"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		Current    bool       `json:"current"`
+	}
+
+	sessions := make([]session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, session{
+			CreatedAt:  token.CreatedAt,
+			Expiry:     token.Expiry,
+			LastUsedAt: token.LastUsedAt,
+			Current:    bytes.Equal(token.Hash, currentHash[:]),
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Logout: delete the authentication token used by the request.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Token.Delete(data.ScopeAuthentication, app.contextGetToken(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+	app.audit(r, "token.delete", "user", user.ID, envelop{"scope": data.ScopeAuthentication}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Logout everywhere: delete all the authentication tokens of the current user.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Token.DeleteAllFromUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "token.delete_all", "user", user.ID, envelop{"scope": data.ScopeAuthentication}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "you have been logged out from all your sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
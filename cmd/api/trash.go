@@ -0,0 +1,112 @@
+package main
+
+import (
+	"SrbastianM/rest-api-gin/internal/data"
+	"SrbastianM/rest-api-gin/internal/validator"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// List the deleted foods that are still in the trash, the last deleted first.
+func (app *application) listFoodTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.Schema = data.TrashSchema
+	input.Filters.Ranges = app.readRanges(qs, data.TrashSchema, v)
+
+	app.rejectUnknownParams(qs, v, append(data.TrashSchema.Params(), "page", "page_size", "sort")...)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	foods, metadata, err := app.models.Foods.GetTrash(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"foods": foods, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Take a food out of the trash, as it was when it was deleted.
+func (app *application) restoreFoodHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Foods.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	food, ok := app.readFoodParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.audit(r, "food.undelete", "food", food.ID, nil, food)
+
+	err = app.writeJSONWithETag(w, r, http.StatusOK, envelop{"food": food}, foodETag(food), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Start the goroutine that deletes for good the foods that are in the trash for longer than
+// the retention. It runs on startup and then every hour (or every retention, if shorter)
+// until the server shuts down, which waits for the purge in progress like for the other
+// background tasks.
+func (app *application) purgeTrash() {
+	retention := app.config.trash.retention
+	ticker := time.NewTicker(min(time.Hour, retention))
+
+	app.wg.Add(1)
+	go func() {
+		defer func() {
+			ticker.Stop()
+			app.wg.Done()
+		}()
+
+		for {
+			app.runRecovered(nil, func() error {
+				purged, err := app.models.Foods.Purge(retention)
+				if err == nil && purged > 0 {
+					app.logger.PrintInfo("purged foods from the trash", map[string]string{
+						"count":     strconv.FormatInt(purged, 10),
+						"retention": retention.String(),
+					})
+				}
+				return err
+			})
+
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// AuditEvent records one write operation: who did it (nil ActorID for the anonymous
+// requests, like a registration), what and on which resource, the fields that changed and
+// where the request came from.
+type AuditEvent struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorID      *int64          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   int64           `json:"resource_id"`
+	Changes      json.RawMessage `json:"changes"`
+	ClientIP     string          `json:"client_ip"`
+	RequestID    string          `json:"request_id"`
+}
+
+// AuditFilter narrows the audit listing, the zero values match everything. The time
+// range goes in the ranges of the Filters.
+type AuditFilter struct {
+	ActorID      int64
+	ResourceType string
+	ResourceID   int64
+}
+
+// AuditSchema declares the sort keys and range filters of the audit listing.
+var AuditSchema = Schema{
+	{Column: "id", Kind: KindNumber, Sort: "id"},
+	{Column: "created_at", Kind: KindTime, Sort: "created_at", MinParam: "from", MaxParam: "to"},
+}
+
+// AuditChanges returns the fields that differ between before and after (as they are
+// encoded in JSON), like {"Title": {"before": "Soup", "after": "Stew"}}. Either of them
+// can be nil, for a creation or a deletion, then the fields only have "after" or "before".
+func AuditChanges(before, after interface{}) (json.RawMessage, error) {
+	b, err := toJSONMap(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toJSONMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]map[string]interface{})
+	for key, value := range b {
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = map[string]interface{}{"before": value}
+		}
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !reflect.DeepEqual(value, other) {
+			if changes[key] == nil {
+				changes[key] = make(map[string]interface{})
+			}
+			changes[key]["after"] = value
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+func toJSONMap(v interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil() {
+		return m, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(js, &m)
+	if err != nil {
+		return nil, fmt.Errorf("audit changes must be a JSON object: %w", err)
+	}
+	return m, nil
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) Insert(event *AuditEvent) error {
+	query := `
+	INSERT INTO audit_events (actor_id, action, resource_type, resource_id, changes, client_ip, request_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	changes := event.Changes
+	if len(changes) == 0 {
+		changes = json.RawMessage("{}")
+	}
+
+	args := []interface{}{event.ActorID, event.Action, event.ResourceType, event.ResourceID, []byte(changes), event.ClientIP, event.RequestID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// Return the events matching the filter and the time range, sorted and paginated.
+func (m AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	args := []interface{}{filter.ActorID, filter.ResourceType, filter.ResourceID, filters.limit(), filters.offset()}
+	rangesWhere, args := filters.where(args)
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, actor_id, action, resource_type, resource_id, changes, client_ip, request_id
+	FROM audit_events
+	WHERE (actor_id = $1 OR $1 = 0)
+	AND (resource_type = $2 OR $2 = '')
+	AND (resource_id = $3 OR $3 = 0)%s
+	ORDER BY %s
+	LIMIT $4 OFFSET $5`, rangesWhere, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var changes []byte
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.ResourceType,
+			&event.ResourceID,
+			&changes,
+			&event.ClientIP,
+			&event.RequestID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		event.Changes = changes
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return events, metadata, nil
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The actions of a bulk operation.
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// FoodOperation is one operation of a bulk request. For a create or an update Food is the
+// whole food to write (the update only happens if the food is still at Food.Version), for
+// a delete only Food.ID is used. After Bulk() Err holds why the operation failed, like
+// ErrRecordNotFound or ErrEditConflict.
+type FoodOperation struct {
+	Action string
+	Food   *Food
+	Err    error
+}
+
+// Run the operations in a single transaction, userID is who makes them (0 for nobody). With
+// atomic the first operation that fails rolls back the whole transaction, otherwise only the
+// failed operations are undone (with a savepoint each) and the rest is committed. The
+// returned error is about the transaction itself, then nothing was written.
+func (f FoodModel) Bulk(ops []*FoodOperation, userID int64, atomic bool) error {
+	// The statements have their own timeout, this one bounds the whole transaction.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := f.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Does nothing once the transaction is committed.
+	defer tx.Rollback()
+
+	saved := make([]Food, 0, len(ops))
+
+	for _, op := range ops {
+		saved = append(saved, *op.Food)
+
+		if !atomic {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT operation"); err != nil {
+				return err
+			}
+		}
+
+		op.Err = runFoodOperation(tx, op, userID)
+
+		switch {
+		case op.Err != nil && atomic:
+			resetFoodOperations(ops, saved)
+			return tx.Rollback()
+		case op.Err != nil:
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT operation")
+		case !atomic:
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT operation")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Put the foods of the operations that ran back as they were, after a rollback the ids and
+// versions the writes scanned into them don't exist.
+func resetFoodOperations(ops []*FoodOperation, saved []Food) {
+	for i, food := range saved {
+		*ops[i].Food = food
+	}
+}
+
+// Run one operation with the writes shared with Insert(), Update() and Delete().
+func runFoodOperation(db dbtx, op *FoodOperation, userID int64) error {
+	switch op.Action {
+	case BulkCreate:
+		return insertFood(db, op.Food, userID)
+	case BulkUpdate:
+		return updateFood(db, op.Food, userID)
+	case BulkDelete:
+		return deleteFood(db, op.Food.ID, op.Food.Version)
+	default:
+		return fmt.Errorf("unknown bulk action %q", op.Action)
+	}
+}
@@ -0,0 +1,197 @@
+package data
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Purge the expired entries once the cache grows over this size, so users that stop
+// sending requests don't stay in memory forever.
+const permissionCacheSweepSize = 10_000
+
+// PermissionCache keeps the effective permissions and the role names of each user in
+// memory for a TTL. Any change of the permissions or roles of a user through the cached
+// models invalidates the entries right away; changes made by other instances are visible
+// once the TTL expires.
+type PermissionCache struct {
+	ttl         time.Duration
+	mu          sync.Mutex
+	permissions map[int64]cacheEntry[Permissions]
+	roles       map[int64]cacheEntry[[]string]
+	// Bumped by Invalidate. A read of the store that started before the invalidation must
+	// not cache what it got, it could be the permissions that were just revoked. Only the
+	// users that were invalidated have one, so they are never swept.
+	generations      map[int64]uint64
+	permissionsStats cacheStats
+	rolesStats       cacheStats
+}
+
+type cacheEntry[T any] struct {
+	value  T
+	expiry time.Time
+}
+
+type cacheStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:         ttl,
+		permissions: make(map[int64]cacheEntry[Permissions]),
+		roles:       make(map[int64]cacheEntry[[]string]),
+		generations: make(map[int64]uint64),
+	}
+}
+
+// Return the generation of the user, read it before going to the store and pass it to
+// cacheSet.
+func (c *PermissionCache) generation(userID int64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[userID]
+}
+
+func cacheGet[S ~[]string](c *PermissionCache, entries map[int64]cacheEntry[S], stats *cacheStats, userID int64) (S, bool) {
+	c.mu.Lock()
+	entry, ok := entries[userID]
+	c.mu.Unlock()
+
+	if !ok || time.Now().After(entry.expiry) {
+		stats.misses.Add(1)
+		return nil, false
+	}
+
+	stats.hits.Add(1)
+	return slices.Clone(entry.value), true
+}
+
+// Store the value unless the user was invalidated since the generation was read.
+func cacheSet[S ~[]string](c *PermissionCache, entries map[int64]cacheEntry[S], userID int64, generation uint64, value S) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[userID] != generation {
+		return
+	}
+
+	now := time.Now()
+
+	if len(entries) >= permissionCacheSweepSize {
+		for id, entry := range entries {
+			if now.After(entry.expiry) {
+				delete(entries, id)
+			}
+		}
+	}
+
+	entries[userID] = cacheEntry[S]{value: slices.Clone(value), expiry: now.Add(c.ttl)}
+}
+
+// Invalidate drops the cached permissions and roles of the user.
+func (c *PermissionCache) Invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.permissions, userID)
+	delete(c.roles, userID)
+	c.generations[userID]++
+}
+
+// Stats returns the hit and miss counters and the current number of entries of the
+// permissions and of the roles.
+func (c *PermissionCache) Stats() map[string]map[string]int64 {
+	c.mu.Lock()
+	permissions, roles := len(c.permissions), len(c.roles)
+	c.mu.Unlock()
+
+	return map[string]map[string]int64{
+		"permissions": {
+			"hits":    c.permissionsStats.hits.Load(),
+			"misses":  c.permissionsStats.misses.Load(),
+			"entries": int64(permissions),
+		},
+		"roles": {
+			"hits":    c.rolesStats.hits.Load(),
+			"misses":  c.rolesStats.misses.Load(),
+			"entries": int64(roles),
+		},
+	}
+}
+
+// CachedPermissionsModel wraps any PermissionStore and serves GetAllForUser from the cache.
+type CachedPermissionsModel struct {
+	PermissionStore
+	Cache *PermissionCache
+}
+
+func (m CachedPermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+	if permissions, ok := cacheGet(m.Cache, m.Cache.permissions, &m.Cache.permissionsStats, userID); ok {
+		return permissions, nil
+	}
+
+	generation := m.Cache.generation(userID)
+
+	permissions, err := m.PermissionStore.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSet(m.Cache, m.Cache.permissions, userID, generation, permissions)
+	return permissions, nil
+}
+
+func (m CachedPermissionsModel) AddForUser(userID int64, codes ...string) error {
+	defer m.Cache.Invalidate(userID)
+	return m.PermissionStore.AddForUser(userID, codes...)
+}
+
+func (m CachedPermissionsModel) RemoveForUser(userID int64, codes ...string) error {
+	defer m.Cache.Invalidate(userID)
+	return m.PermissionStore.RemoveForUser(userID, codes...)
+}
+
+// CachedRoleModel wraps any RoleStore, it serves GetAllForUser from the cache and
+// invalidates the cached permissions when the roles of a user change.
+type CachedRoleModel struct {
+	RoleStore
+	Cache *PermissionCache
+}
+
+func (m CachedRoleModel) GetAllForUser(userID int64) ([]string, error) {
+	if names, ok := cacheGet(m.Cache, m.Cache.roles, &m.Cache.rolesStats, userID); ok {
+		return names, nil
+	}
+
+	generation := m.Cache.generation(userID)
+
+	names, err := m.RoleStore.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSet(m.Cache, m.Cache.roles, userID, generation, names)
+	return names, nil
+}
+
+func (m CachedRoleModel) AddForUser(userID int64, names ...string) error {
+	defer m.Cache.Invalidate(userID)
+	return m.RoleStore.AddForUser(userID, names...)
+}
+
+func (m CachedRoleModel) RemoveForUser(userID int64, names ...string) error {
+	defer m.Cache.Invalidate(userID)
+	return m.RoleStore.RemoveForUser(userID, names...)
+}
+
+// WithPermissionCache returns a copy of the models where the permissions and roles go
+// through the given cache.
+func (m Models) WithPermissionCache(cache *PermissionCache) Models {
+	m.Permissions = CachedPermissionsModel{PermissionStore: m.Permissions, Cache: cache}
+	m.Roles = CachedRoleModel{RoleStore: m.Roles, Cache: cache}
+	return m
+}
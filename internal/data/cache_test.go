@@ -0,0 +1,105 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A store that returns what it's told and can run something in the middle of a read, like
+// a concurrent change of the permissions.
+type stubPermissionStore struct {
+	PermissionStore
+	permissions Permissions
+	during      func()
+	reads       int
+}
+
+func (s *stubPermissionStore) GetAllForUser(userID int64) (Permissions, error) {
+	s.reads++
+	permissions := s.permissions
+	if s.during != nil {
+		s.during()
+		s.during = nil
+	}
+	return permissions, nil
+}
+
+type stubRoleStore struct {
+	RoleStore
+	names []string
+	reads int
+}
+
+func (s *stubRoleStore) GetAllForUser(userID int64) ([]string, error) {
+	s.reads++
+	return s.names, nil
+}
+
+func (s *stubRoleStore) AddForUser(userID int64, names ...string) error {
+	s.names = append(s.names, names...)
+	return nil
+}
+
+func TestCachedPermissionsInvalidatedDuringRead(t *testing.T) {
+	cache := NewPermissionCache(time.Minute)
+	store := &stubPermissionStore{permissions: Permissions{"foods:read", "foods:write"}}
+	model := CachedPermissionsModel{PermissionStore: store, Cache: cache}
+
+	// foods:write is revoked while the read is running, what it got must not be cached.
+	store.during = func() {
+		store.permissions = Permissions{"foods:read"}
+		cache.Invalidate(1)
+	}
+
+	tests := []struct {
+		name      string
+		want      Permissions
+		wantReads int
+	}{
+		{"read that raced the invalidation", Permissions{"foods:read", "foods:write"}, 1},
+		{"stale result wasn't cached", Permissions{"foods:read"}, 2},
+		{"fresh result is cached", Permissions{"foods:read"}, 2},
+	}
+
+	for _, tt := range tests {
+		got, err := model.GetAllForUser(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) || store.reads != tt.wantReads {
+			t.Errorf("%s: got %v after %d reads, want %v after %d", tt.name, got, store.reads, tt.want, tt.wantReads)
+		}
+	}
+}
+
+func TestPermissionCacheStats(t *testing.T) {
+	cache := NewPermissionCache(time.Minute)
+	models := Models{
+		Permissions: &stubPermissionStore{permissions: Permissions{"foods:read"}},
+		Roles:       &stubRoleStore{names: []string{"viewer"}},
+	}.WithPermissionCache(cache)
+
+	models.Permissions.GetAllForUser(1)
+	models.Permissions.GetAllForUser(1)
+	models.Permissions.GetAllForUser(2)
+	models.Roles.GetAllForUser(1)
+
+	want := map[string]map[string]int64{
+		"permissions": {"hits": 1, "misses": 2, "entries": 2},
+		"roles":       {"hits": 0, "misses": 1, "entries": 1},
+	}
+	if got := cache.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	models.Roles.AddForUser(1, "editor")
+
+	want = map[string]map[string]int64{
+		"permissions": {"hits": 1, "misses": 2, "entries": 1},
+		"roles":       {"hits": 0, "misses": 1, "entries": 0},
+	}
+	if got := cache.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after the invalidation got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// A Cursor points to the row at the edge of a page in keyset pagination. Values holds
+// the value of every sort key of that row (the id is always the last one) and Sort the
+// sort parameter it was created with, so a cursor cannot be reused with another order.
+// Backward cursors fetch the page before the row instead of the page after it.
+type Cursor struct {
+	Sort     string
+	Values   []interface{}
+	Backward bool
+}
+
+// CursorPage describes where a keyset page sits: the cursors of the next and previous
+// pages (nil if there isn't any) and the total, nil if the count was skipped.
+type CursorPage struct {
+	Next         *Cursor
+	Prev         *Cursor
+	TotalRecords *int
+}
+
+// CursorMetadata is the metadata sent to the client in cursor mode.
+type CursorMetadata struct {
+	PageSize     int    `json:"page_size"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+	TotalRecords *int   `json:"total_records,omitempty"`
+}
+
+type cursorPayload struct {
+	Sort     string        `json:"s"`
+	Values   []interface{} `json:"v"`
+	Backward bool          `json:"b,omitempty"`
+}
+
+// Metadata returns the metadata of the page with the cursors encoded and signed.
+func (p CursorPage) Metadata(pageSize int, secret []byte) CursorMetadata {
+	metadata := CursorMetadata{
+		PageSize:     pageSize,
+		TotalRecords: p.TotalRecords,
+	}
+	if p.Next != nil {
+		metadata.NextCursor = p.Next.Encode(secret)
+	}
+	if p.Prev != nil {
+		metadata.PrevCursor = p.Prev.Encode(secret)
+	}
+	return metadata
+}
+
+// Encode returns the opaque representation of the cursor: the base64 JSON payload and its
+// HMAC-SHA256 signature, so clients can't forge or tamper with the values.
+func (c Cursor) Encode(secret []byte) string {
+	payload, _ := json.Marshal(cursorPayload{Sort: c.Sort, Values: c.Values, Backward: c.Backward})
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(secret, encoded))
+}
+
+// DecodeCursor checks the signature of the cursor and converts its values to the kind of
+// the sort fields in the schema.
+func DecodeCursor(s string, secret []byte, schema Schema) (*Cursor, error) {
+	encoded, signature, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, sign(secret, encoded)) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var p cursorPayload
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &Cursor{Sort: p.Sort, Backward: p.Backward}
+
+	keys := Filters{Sort: p.Sort, Schema: schema}.sortKeys()
+	if len(keys) != len(p.Values) {
+		return nil, ErrInvalidCursor
+	}
+
+	for i, key := range keys {
+		value, err := decodeCursorValue(schema, key.column, p.Values[i])
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+
+	return cursor, nil
+}
+
+func decodeCursorValue(schema Schema, column string, raw interface{}) (interface{}, error) {
+	var field Field
+	for _, f := range schema {
+		if f.Column == column {
+			field = f
+		}
+	}
+
+	switch field.Kind {
+	case KindNumber:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		if column == "id" {
+			return n.Int64()
+		}
+		return n.Float64()
+	case KindTime:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		return s, nil
+	}
+}
+
+func sign(secret []byte, encoded string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+// Return the SQL condition that keeps the rows after the cursor in the sort order (or
+// before it for backward cursors). With mixed directions a row comparison doesn't work,
+// so it expands to: k1 > v1 OR (k1 = v1 AND k2 < v2) OR (k1 = v1 AND k2 = v2 AND id > v3)
+func (f Filters) keysetWhere(args []interface{}) (string, []interface{}) {
+	if f.Cursor == nil {
+		return "", args
+	}
+
+	var (
+		ors    []string
+		equals []string
+	)
+
+	for i, key := range f.sortKeys() {
+		args = append(args, f.Cursor.Values[i])
+		placeholder := fmt.Sprintf("$%d", len(args))
+
+		op := ">"
+		if key.desc != f.Cursor.Backward {
+			op = "<"
+		}
+
+		ors = append(ors, "("+strings.Join(append(append([]string{}, equals...), key.column+" "+op+" "+placeholder), " AND ")+")")
+		equals = append(equals, key.column+" = "+placeholder)
+	}
+
+	return " AND (" + strings.Join(ors, " OR ") + ")", args
+}
+
+// Return the ORDER BY clause to fetch a keyset page, reversed for backward cursors (the
+// rows are put back in order by cursorPage).
+func (f Filters) keysetOrderBy() string {
+	var parts []string
+	for _, key := range f.sortKeys() {
+		direction := "ASC"
+		if key.desc != (f.Cursor != nil && f.Cursor.Backward) {
+			direction = "DESC"
+		}
+		parts = append(parts, key.column+" "+direction)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Report whether the row comes after the cursor in the sort order (or before it for backward
+// cursors), used by the in-memory models instead of keysetWhere().
+func (f Filters) afterCursor(value func(column string) interface{}) bool {
+	if f.Cursor == nil {
+		return true
+	}
+
+	for i, key := range f.sortKeys() {
+		c := compareValues(value(key.column), f.Cursor.Values[i])
+		if key.desc {
+			c = -c
+		}
+		if f.Cursor.Backward {
+			c = -c
+		}
+		if c != 0 {
+			return c > 0
+		}
+	}
+	return false
+}
+
+// Build the cursor pointing to a row.
+func (f Filters) cursorFor(value func(column string) interface{}, backward bool) *Cursor {
+	cursor := &Cursor{Sort: f.Sort, Backward: backward}
+	for _, key := range f.sortKeys() {
+		cursor.Values = append(cursor.Values, value(key.column))
+	}
+	return cursor
+}
+
+// Trim the rows of a keyset page (fetched with PageSize+1 rows to know if there are more),
+// put them back in sort order and build the cursors of the next and previous pages.
+func cursorPage[T any](f Filters, rows []T, value func(row T, column string) interface{}) ([]T, CursorPage) {
+	more := len(rows) > f.PageSize
+	if more {
+		rows = rows[:f.PageSize]
+	}
+
+	backward := f.Cursor != nil && f.Cursor.Backward
+
+	hasNext, hasPrev := more, f.Cursor != nil
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+		hasNext, hasPrev = true, more
+	}
+
+	var page CursorPage
+
+	if len(rows) == 0 {
+		return rows, page
+	}
+
+	if hasNext {
+		last := rows[len(rows)-1]
+		page.Next = f.cursorFor(func(column string) interface{} { return value(last, column) }, false)
+	}
+	if hasPrev {
+		first := rows[0]
+		page.Prev = f.cursorFor(func(column string) interface{} { return value(first, column) }, true)
+	}
+
+	return rows, page
+}
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	secret := []byte("secret")
+	created := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+
+	valid := Cursor{Sort: "-created_at,title", Values: []interface{}{created, "Apple", int64(7)}, Backward: true}
+	encoded := valid.Encode(secret)
+	payload, signature, _ := strings.Cut(encoded, ".")
+
+	// The same signature with another payload, like a client changing the values.
+	forged := Cursor{Sort: valid.Sort, Values: []interface{}{created, "Apple", int64(1)}, Backward: true}.Encode(secret)
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	// Signed with the right secret but not matching the schema.
+	sign := func(sort string, values ...interface{}) string {
+		return Cursor{Sort: sort, Values: values}.Encode(secret)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		secret []byte
+		schema Schema
+		want   *Cursor
+	}{
+		{"valid", encoded, secret, FoodSchema, &valid},
+		{"numbers", sign("kcal", 52.5, 3), secret, FoodSchema, &Cursor{Sort: "kcal", Values: []interface{}{52.5, int64(3)}}},
+		{"tampered payload", forgedPayload + "." + signature, secret, FoodSchema, nil},
+		{"tampered signature", payload + "." + base64.RawURLEncoding.EncodeToString([]byte("nope")), secret, FoodSchema, nil},
+		{"signature is not base64", payload + ".!!", secret, FoodSchema, nil},
+		{"payload is not base64", "!!." + signature, secret, FoodSchema, nil},
+		{"no signature", payload, secret, FoodSchema, nil},
+		{"empty", "", secret, FoodSchema, nil},
+		{"wrong secret", encoded, []byte("another secret"), FoodSchema, nil},
+		{"fewer values than sort keys", sign("title", "Apple"), secret, FoodSchema, nil},
+		{"more values than sort keys", sign("title", "Apple", 7, 8), secret, FoodSchema, nil},
+		{"sort key not in the schema", encoded, secret, Schema{{Column: "id", Kind: KindNumber, Sort: "id"}}, nil},
+		{"text instead of a number", sign("kcal", "52", 3), secret, FoodSchema, nil},
+		{"number instead of a time", sign("created_at", 1700000000, 3), secret, FoodSchema, nil},
+		{"malformed time", sign("created_at", "yesterday", 3), secret, FoodSchema, nil},
+		{"number instead of text", sign("title", 5, 3), secret, FoodSchema, nil},
+		{"fractional id", sign("id", 1.5), secret, FoodSchema, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCursor(tt.cursor, tt.secret, tt.schema)
+
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Fatalf("got %+v, %v; want ErrInvalidCursor", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetWhere(t *testing.T) {
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		sort     string
+		cursor   *Cursor
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			name: "first page",
+			sort: "title",
+		},
+		{
+			name:     "id",
+			sort:     "id",
+			cursor:   &Cursor{Values: []interface{}{int64(7)}},
+			want:     " AND ((id > $2))",
+			wantArgs: []interface{}{int64(7)},
+		},
+		{
+			name:     "id descending",
+			sort:     "-id",
+			cursor:   &Cursor{Values: []interface{}{int64(7)}},
+			want:     " AND ((id < $2))",
+			wantArgs: []interface{}{int64(7)},
+		},
+		{
+			name:     "id descending backward",
+			sort:     "-id",
+			cursor:   &Cursor{Values: []interface{}{int64(7)}, Backward: true},
+			want:     " AND ((id > $2))",
+			wantArgs: []interface{}{int64(7)},
+		},
+		{
+			name:     "id tie-breaker",
+			sort:     "title",
+			cursor:   &Cursor{Values: []interface{}{"Apple", int64(7)}},
+			want:     " AND ((title > $2) OR (title = $2 AND id > $3))",
+			wantArgs: []interface{}{"Apple", int64(7)},
+		},
+		{
+			name:     "mixed directions",
+			sort:     "-created_at,title",
+			cursor:   &Cursor{Values: []interface{}{created, "Apple", int64(7)}},
+			want:     " AND ((created_at < $2) OR (created_at = $2 AND title > $3) OR (created_at = $2 AND title = $3 AND id > $4))",
+			wantArgs: []interface{}{created, "Apple", int64(7)},
+		},
+		{
+			name:     "mixed directions backward",
+			sort:     "-created_at,title",
+			cursor:   &Cursor{Values: []interface{}{created, "Apple", int64(7)}, Backward: true},
+			want:     " AND ((created_at > $2) OR (created_at = $2 AND title < $3) OR (created_at = $2 AND title = $3 AND id < $4))",
+			wantArgs: []interface{}{created, "Apple", int64(7)},
+		},
+		{
+			name:     "explicit id descending ends the keys",
+			sort:     "kcal,-protein,-id,title",
+			cursor:   &Cursor{Values: []interface{}{52.5, 0.3, int64(7)}},
+			want:     " AND ((energy_kcal > $2) OR (energy_kcal = $2 AND protein < $3) OR (energy_kcal = $2 AND protein = $3 AND id < $4))",
+			wantArgs: []interface{}{52.5, 0.3, int64(7)},
+		},
+		{
+			name:     "explicit id descending backward",
+			sort:     "kcal,-protein,-id,title",
+			cursor:   &Cursor{Values: []interface{}{52.5, 0.3, int64(7)}, Backward: true},
+			want:     " AND ((energy_kcal < $2) OR (energy_kcal = $2 AND protein > $3) OR (energy_kcal = $2 AND protein = $3 AND id > $4))",
+			wantArgs: []interface{}{52.5, 0.3, int64(7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, Schema: FoodSchema, Cursor: tt.cursor}
+
+			// One argument already used by the query, the placeholders must follow it.
+			got, args := f.keysetWhere([]interface{}{"title"})
+
+			if got != tt.want {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+			if wantArgs := append([]interface{}{"title"}, tt.wantArgs...); !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("got args %v, want %v", args, wantArgs)
+			}
+		})
+	}
+}
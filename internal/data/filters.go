@@ -2,15 +2,54 @@ package data
 
 import (
 	"SrbastianM/rest-api-gin/internal/validator"
+	"fmt"
 	"math"
 	"strings"
+	"time"
 )
 
+// FieldKind is the type of the values of a field, it tells the handlers how to read
+// the range parameters from the query string.
+type FieldKind int
+
+const (
+	KindNumber FieldKind = iota
+	KindTime
+	KindText
+)
+
+// Field declares what a listing endpoint can do with a column: sort on it (Sort is the key
+// used in the sort parameter) and filter it by range (MinParam/MaxParam are the query
+// string parameters for the bounds). Empty names disable the feature. If Strict is true
+// the bounds are exclusive (created_after, created_before), otherwise inclusive.
+type Field struct {
+	Column   string
+	Kind     FieldKind
+	Sort     string
+	MinParam string
+	MaxParam string
+	Strict   bool
+}
+
+// Schema is the list of fields supported by a listing endpoint.
+type Schema []Field
+
+// Range is a parsed range filter. Min and Max are float64 or time.Time depending on the
+// field kind, nil means there is no bound on that side.
+type Range struct {
+	Field Field
+	Min   interface{}
+	Max   interface{}
+}
+
 type Filters struct {
-	Page         int
-	PageSize     int
-	Sort         string
-	SortSafeList []string
+	Page     int
+	PageSize int
+	Sort     string // Comma separated sort keys, a leading hyphen means descending: -created_at,title
+	Schema   Schema
+	Ranges   []Range
+	Cursor   *Cursor // Only used in cursor mode, nil is the first page
+	Count    bool    // Only used in cursor mode, count the total records too
 }
 
 type Metadata struct {
@@ -21,24 +60,154 @@ type Metadata struct {
 	TotalRecords int
 }
 
-// Check that the client-provided Sort fiel matches one of the entries in our SortSafeList
-// and if it does, extract the column name from the Sort filed and stripping the leading
-// hyphen character (if exists)
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafeList {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// Params returns every query string parameter the schema understands.
+func (s Schema) Params() []string {
+	var params []string
+	for _, field := range s {
+		if field.MinParam != "" {
+			params = append(params, field.MinParam)
+		}
+		if field.MaxParam != "" {
+			params = append(params, field.MaxParam)
+		}
+	}
+	return params
+}
+
+func (s Schema) sortField(key string) (Field, bool) {
+	for _, field := range s {
+		if field.Sort != "" && field.Sort == key {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// Split the Sort field into the columns and directions. Unknown keys are skipped (they
+// were already rejected by ValidateFilters) and the id is always the last tie-breaker so
+// the pagination is stable.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	hasID := false
+
+	for _, key := range strings.Split(f.Sort, ",") {
+		key = strings.TrimSpace(key)
+		field, ok := f.Schema.sortField(strings.TrimPrefix(key, "-"))
+		if !ok {
+			continue
+		}
+		keys = append(keys, sortKey{column: field.Column, desc: strings.HasPrefix(key, "-")})
+		if field.Column == "id" {
+			hasID = true
+			break
+		}
+	}
+
+	if !hasID {
+		keys = append(keys, sortKey{column: "id"})
+	}
+	return keys
+}
+
+// Return the ORDER BY clause (without the keywords) for the sort keys.
+func (f Filters) orderBy() string {
+	var parts []string
+	for _, key := range f.sortKeys() {
+		direction := "ASC"
+		if key.desc {
+			direction = "DESC"
+		}
+		parts = append(parts, key.column+" "+direction)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Return the SQL conditions for the ranges, numbering the placeholders after the
+// arguments already used by the query.
+func (f Filters) where(args []interface{}) (string, []interface{}) {
+	var clause string
+	for _, r := range f.Ranges {
+		lower, upper := ">=", "<="
+		if r.Field.Strict {
+			lower, upper = ">", "<"
+		}
+		if r.Min != nil {
+			args = append(args, r.Min)
+			clause += fmt.Sprintf(" AND %s %s $%d", r.Field.Column, lower, len(args))
+		}
+		if r.Max != nil {
+			args = append(args, r.Max)
+			clause += fmt.Sprintf(" AND %s %s $%d", r.Field.Column, upper, len(args))
+		}
+	}
+	return clause, args
+}
+
+// Report whether the values returned by value(column) are inside every range, used by
+// the in-memory models instead of where().
+func (f Filters) match(value func(column string) interface{}) bool {
+	for _, r := range f.Ranges {
+		v := value(r.Field.Column)
+		if r.Min != nil {
+			c := compareValues(v, r.Min)
+			if c < 0 || (r.Field.Strict && c == 0) {
+				return false
+			}
+		}
+		if r.Max != nil {
+			c := compareValues(v, r.Max)
+			if c > 0 || (r.Field.Strict && c == 0) {
+				return false
+			}
 		}
 	}
-	return "id"
+	return true
 }
 
-// Return sort direction (ASC or DESC) depending the prefix character of the Sort Field
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// Compare the rows a and b with the sort keys, value(row, column) returns the value of
+// the column for the row. Used by the in-memory models instead of orderBy().
+func (f Filters) less(a, b interface{}, value func(row interface{}, column string) interface{}) bool {
+	for _, key := range f.sortKeys() {
+		c := compareValues(value(a, key.column), value(b, key.column))
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		return compareOrdered(a, b.(int64))
+	case float64:
+		return compareOrdered(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return 0
+	}
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
 	}
-	return "ASC"
 }
 
 func (f Filters) limit() int {
@@ -55,7 +224,27 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	// Every sort key must be declared in the schema and appear only once.
+	var keys []string
+	for _, key := range strings.Split(f.Sort, ",") {
+		key = strings.TrimPrefix(strings.TrimSpace(key), "-")
+		if _, ok := f.Schema.sortField(key); !ok {
+			v.AddError("sort", fmt.Sprintf("invalid sort key %q", key))
+			continue
+		}
+		keys = append(keys, key)
+	}
+	v.Check(validator.Unique(keys), "sort", "must not contain duplicate keys")
+
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "was created with another sort")
+	}
+
+	for _, r := range f.Ranges {
+		if r.Min != nil && r.Max != nil {
+			v.Check(compareValues(r.Min, r.Max) <= 0, r.Field.MinParam, "must not be greater than "+r.Field.MaxParam)
+		}
+	}
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -12,11 +12,30 @@ import (
 )
 
 type Food struct {
-	ID       int64     // Unique integer for the Food
-	CreateAt time.Time // TimeStamp when the Food is added to our db
-	Title    string    // Food Title
-	Types    []string  // Slices of types of food (Fruit and vegetables, starchy food, Dairy. Protein, fat)
-	Version  int32     // Version number starts wiht 1 and will be incremented each time food information is updated
+	ID          int64      // Unique integer for the Food
+	CreateAt    time.Time  // TimeStamp when the Food is added to our db
+	Title       string     // Food Title
+	Types       []string   // Slices of types of food (Fruit and vegetables, starchy food, Dairy. Protein, fat)
+	Nutrition   Nutrition  // Nutrition facts per 100g
+	ServingSize float64    // Grams in one serving, 0 if unknown
+	Version     int32      // Version number starts wiht 1 and will be incremented each time food information is updated
+	DeletedAt   *time.Time `json:",omitempty"` // When the food was moved to the trash, nil if it wasn't
+}
+
+// FoodSchema declares the sort keys and range filters of the foods listing.
+var FoodSchema = Schema{
+	{Column: "id", Kind: KindNumber, Sort: "id"},
+	{Column: "title", Kind: KindText, Sort: "title"},
+	{Column: "created_at", Kind: KindTime, Sort: "created_at", MinParam: "created_after", MaxParam: "created_before", Strict: true},
+	{Column: "energy_kcal", Kind: KindNumber, Sort: "kcal", MinParam: "kcal_min", MaxParam: "kcal_max"},
+	{Column: "protein", Kind: KindNumber, Sort: "protein", MinParam: "protein_min", MaxParam: "protein_max"},
+	{Column: "fat", Kind: KindNumber, Sort: "fat", MinParam: "fat_min", MaxParam: "fat_max"},
+	{Column: "saturated_fat", Kind: KindNumber, Sort: "saturated_fat", MinParam: "saturated_fat_min", MaxParam: "saturated_fat_max"},
+	{Column: "carbohydrates", Kind: KindNumber, Sort: "carbs", MinParam: "carbs_min", MaxParam: "carbs_max"},
+	{Column: "sugar", Kind: KindNumber, Sort: "sugar", MinParam: "sugar_min", MaxParam: "sugar_max"},
+	{Column: "fibre", Kind: KindNumber, Sort: "fibre", MinParam: "fibre_min", MaxParam: "fibre_max"},
+	{Column: "salt", Kind: KindNumber, Sort: "salt", MinParam: "salt_min", MaxParam: "salt_max"},
+	{Column: "serving_size", Kind: KindNumber, Sort: "serving_size", MinParam: "serving_size_min", MaxParam: "serving_size_max"},
 }
 
 // Define a FoodModel Struct type which wraps a sql.DB connection pools.
@@ -24,37 +43,36 @@ type FoodModel struct {
 	DB *sql.DB
 }
 
-// // Create mock to Unit test all of the methods: Create, Get, Update and Delete
-// type MockFoodModel struct{}
-
-// func (f MockFoodModel) Insert(food *Food) error {
-// 	return nil
-// }
-
-// func (f MockFoodModel) Get(id int64) (*Food, error) {
-// 	return nil, nil
-// }
+// The queries that write the foods run on a *sql.DB or, for the bulk operations, a *sql.Tx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-// func (f MockFoodModel) Update(food *Food) error {
-// 	return nil
-// }
+// Add placeholder method for inserting a new record in the food table. The first revision
+// of the food is written by the same statement, userID is who creates it (0 for nobody).
+func (f FoodModel) Insert(food *Food, userID int64) error {
+	return insertFood(f.DB, food, userID)
+}
 
-// func (f MockFoodModel) Delete(id int64) error {
-// 	return nil
-// }
+func insertFood(db dbtx, food *Food, userID int64) error {
+	query := `
+	WITH food AS (
+		INSERT INTO foods (title, type, energy_kcal, protein, fat, saturated_fat, carbohydrates, sugar, fibre, salt, serving_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING *
+	), revision AS (
+		INSERT INTO food_revisions (food_id, version, changed_by, title, type, ` + nutritionColumns + `)
+		SELECT id, version, $12, title, type, ` + nutritionColumns + ` FROM food
+	)
+	SELECT id, created_at, version FROM food`
 
-// Add placeholder method for inserting a new record in the food table.
-func (f FoodModel) Insert(food *Food) error {
-	query :=
-		`INSERT INTO foods (title, type)
-	 VALUES ($1, $2)
-	 RETURNING id, created_at, version
-	`
-	args := []interface{}{food.Title, pq.Array(food.Types)}
+	args := append([]interface{}{food.Title, pq.Array(food.Types)}, nutritionArgs(food)...)
+	args = append(args, nullUserID(userID))
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return f.DB.QueryRowContext(ctx, query, args...).Scan(&food.ID, &food.CreateAt, &food.Version)
+	return db.QueryRowContext(ctx, query, args...).Scan(&food.ID, &food.CreateAt, &food.Version)
 }
 
 // Add placeholder method for fetching a specific record from the food table.
@@ -66,7 +84,7 @@ func (f FoodModel) Get(id int64) (*Food, error) {
 		return nil, ErrRecordNotFound
 	}
 	// Define the SQL Query for retrieving the movie data
-	query := `SELECT id, created_at, title, type, version FROM foods WHERE id = $1`
+	query := `SELECT id, created_at, title, type, ` + nutritionColumns + `, version FROM foods WHERE id = $1 AND deleted_at IS NULL`
 	// Declare de Food struct to hold the data returning by the query
 	var food Food
 
@@ -75,13 +93,8 @@ func (f FoodModel) Get(id int64) (*Food, error) {
 	// Executes a query using QueryRow() method, passing provided id value
 	// as a placeholder parameter, and scan the response data into the fields
 	// the Movie struct
-	err := f.DB.QueryRowContext(ctx, query, id).Scan(
-		&food.ID,
-		&food.CreateAt,
-		&food.Title,
-		pq.Array(&food.Types),
-		&food.Version,
-	)
+	dest := append([]interface{}{&food.ID, &food.CreateAt, &food.Title, pq.Array(&food.Types)}, nutritionDest(&food)...)
+	err := f.DB.QueryRowContext(ctx, query, id).Scan(append(dest, &food.Version)...)
 	// Handle any errors. If there no matching movie found. Otherwise return a pointer to the
 	// Food struct
 	if err != nil {
@@ -97,21 +110,24 @@ func (f FoodModel) Get(id int64) (*Food, error) {
 
 // Add placeholder method to retrieve all the records from the food table.
 func (f FoodModel) GetAll(title string, types []string, filters Filters) ([]*Food, Metadata, error) {
+	args := []interface{}{title, pq.Array(types), filters.limit(), filters.offset()}
+	// Add a condition (and an argument) for every bound of the range filters.
+	rangesWhere, args := filters.where(args)
+
 	// Create a new GetAll() method wich return a slice of movies.
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, type, version
+	SELECT count(*) OVER(), id, created_at, title, type, %s, version
 	FROM foods
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (type && $2 OR $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	WHERE deleted_at IS NULL
+	AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (type && $2 OR $2 = '{}')%s
+	ORDER BY %s
+	LIMIT $3 OFFSET $4`, nutritionColumns, rangesWhere, filters.orderBy())
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(types), filters.limit(), filters.offset()}
-
 	// Execute the query and return an sql.Rows result set containing the result
 	rows, err := f.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -128,14 +144,8 @@ func (f FoodModel) GetAll(title string, types []string, filters Filters) ([]*Foo
 	for rows.Next() {
 		// Initilize an empty Food struct to hold the data for an individual food
 		var food Food
-		err := rows.Scan(
-			&totalRecord,
-			&food.ID,
-			&food.CreateAt,
-			&food.Title,
-			pq.Array(&food.Types),
-			&food.Version,
-		)
+		dest := append([]interface{}{&totalRecord, &food.ID, &food.CreateAt, &food.Title, pq.Array(&food.Types)}, nutritionDest(&food)...)
+		err := rows.Scan(append(dest, &food.Version)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -152,10 +162,87 @@ func (f FoodModel) GetAll(title string, types []string, filters Filters) ([]*Foo
 	return foods, metadata, nil
 }
 
-// Add a placeholder method for updating a specific record in the food table.
-func (f FoodModel) Update(food *Food) error {
+// GetAllByCursor is the keyset version of GetAll(): instead of an OFFSET it starts right
+// after (or before) the row of filters.Cursor, so deep pages cost the same as the first
+// one and rows inserted meanwhile don't shift the pages. The count is a separate query
+// and only runs if filters.Count is set.
+func (f FoodModel) GetAllByCursor(title string, types []string, filters Filters) ([]*Food, CursorPage, error) {
+	args := []interface{}{title, pq.Array(types)}
+	rangesWhere, args := filters.where(args)
+	keysetWhere, pageArgs := filters.keysetWhere(append([]interface{}{}, args...))
+	// Fetch one more row to know if there is another page.
+	pageArgs = append(pageArgs, filters.limit()+1)
+
+	where := `
+	WHERE deleted_at IS NULL
+	AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (type && $2 OR $2 = '{}')` + rangesWhere
+
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, type, %s, version
+	FROM foods%s%s
+	ORDER BY %s
+	LIMIT $%d`, nutritionColumns, where, keysetWhere, filters.keysetOrderBy(), len(pageArgs))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := f.DB.QueryContext(ctx, query, pageArgs...)
+	if err != nil {
+		return nil, CursorPage{}, err
+	}
+	defer rows.Close()
+
+	foods := []*Food{}
+
+	for rows.Next() {
+		var food Food
+		dest := append([]interface{}{&food.ID, &food.CreateAt, &food.Title, pq.Array(&food.Types)}, nutritionDest(&food)...)
+		err := rows.Scan(append(dest, &food.Version)...)
+		if err != nil {
+			return nil, CursorPage{}, err
+		}
+		foods = append(foods, &food)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, CursorPage{}, err
+	}
+
+	foods, page := cursorPage(filters, foods, (*Food).column)
+
+	if filters.Count {
+		var totalRecords int
+
+		err = f.DB.QueryRowContext(ctx, `SELECT count(*) FROM foods`+where, args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, CursorPage{}, err
+		}
+		page.TotalRecords = &totalRecords
+	}
+
+	return foods, page, nil
+}
+
+// Add a placeholder method for updating a specific record in the food table. The new
+// version is saved in the food revisions by the same statement, userID is who changes it.
+func (f FoodModel) Update(food *Food, userID int64) error {
+	return updateFood(f.DB, food, userID)
+}
+
+func updateFood(db dbtx, food *Food, userID int64) error {
 	// Declare SQL query for updating the record and returning the new version number
-	query := `UPDATE foods SET title = $1, type = $2, version = version + 1 WHERE id = $3 AND version =$4 RETURNING version`
+	query := `
+	WITH food AS (
+		UPDATE foods
+		SET title = $1, type = $2, energy_kcal = $5, protein = $6, fat = $7, saturated_fat = $8,
+			carbohydrates = $9, sugar = $10, fibre = $11, salt = $12, serving_size = $13, version = version + 1
+		WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+		RETURNING *
+	), revision AS (
+		INSERT INTO food_revisions (food_id, version, changed_by, title, type, ` + nutritionColumns + `)
+		SELECT id, version, $14, title, type, ` + nutritionColumns + ` FROM food
+	)
+	SELECT version FROM food`
 
 	// Create args slice containing the values for the placeholder parameters.
 	arg := []interface{}{
@@ -164,24 +251,42 @@ func (f FoodModel) Update(food *Food) error {
 		food.ID,
 		food.Version,
 	}
+	arg = append(arg, nutritionArgs(food)...)
+	arg = append(arg, nullUserID(userID))
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	// Use the QueryRow() to execute the query, passing args slices as a variadic parameter and scanning
-	// the new version value into the food struct
-	return f.DB.QueryRowContext(ctx, query, arg...).Scan(&food.Version)
+	// the new version value into the food struct. If no rows matched, the version changed since the
+	// food was read (or it was deleted), so return an ErrEditConflict error.
+	err := db.QueryRowContext(ctx, query, arg...).Scan(&food.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
-// Add a placeholder method for deleting a specific record from movies table.
-func (f FoodModel) Delete(id int64) error {
+// Move the food to the trash. It's not deleted for good until Purge() runs, Restore()
+// brings it back. Like Update() the food must still be at the version, if it changed
+// since it was read it returns ErrEditConflict.
+func (f FoodModel) Delete(id int64, version int32) error {
+	return deleteFood(f.DB, id, version)
+}
+
+func deleteFood(db dbtx, id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	query := `DELETE FROM foods WHERE id=$1`
+	query := `UPDATE foods SET deleted_at = NOW() WHERE id = $1 AND version = $2 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := f.DB.ExecContext(ctx, query, id)
+	result, err := db.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -191,8 +296,19 @@ func (f FoodModel) Delete(id int64) error {
 		return err
 	}
 
+	// Nothing matched, tell apart a food that is gone from one that changed.
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		var exists bool
+		query = `SELECT EXISTS(SELECT 1 FROM foods WHERE id = $1 AND deleted_at IS NULL)`
+		err = db.QueryRowContext(ctx, query, id).Scan(&exists)
+		switch {
+		case err != nil:
+			return err
+		case exists:
+			return ErrEditConflict
+		default:
+			return ErrRecordNotFound
+		}
 	}
 
 	return nil
@@ -208,4 +324,21 @@ func ValidateFood(v *validator.Validator, food *Food) {
 	v.Check(len(food.Types) <= 5, "types", "must not contain more than 5 type")
 
 	v.Check(validator.Unique(food.Types), "types", "must not contain duplicate values")
+	// Nutrition facts
+	ValidateNutrition(v, food.Nutrition)
+	v.Check(food.ServingSize >= 0, "serving_size", "must not be negative")
+	v.Check(food.ServingSize <= 5000, "serving_size", "must not be more than 5000 grams")
+}
+
+// The nutrition columns in the same order as nutritionArgs() and nutritionDest().
+const nutritionColumns = `energy_kcal, protein, fat, saturated_fat, carbohydrates, sugar, fibre, salt, serving_size`
+
+func nutritionArgs(food *Food) []interface{} {
+	n := food.Nutrition
+	return []interface{}{n.EnergyKcal, n.Protein, n.Fat, n.SaturatedFat, n.Carbohydrates, n.Sugar, n.Fibre, n.Salt, food.ServingSize}
+}
+
+func nutritionDest(food *Food) []interface{} {
+	n := &food.Nutrition
+	return []interface{}{&n.EnergyKcal, &n.Protein, &n.Fat, &n.SaturatedFat, &n.Carbohydrates, &n.Sugar, &n.Fibre, &n.Salt, &food.ServingSize}
 }
@@ -0,0 +1,1041 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore holds the in-memory "tables" shared by all the memory models. Every
+// model locks the same mutex so the joins between tables (tokens -> users, users ->
+// permissions) always see a consistent state.
+type memoryStore struct {
+	mu sync.RWMutex
+
+	foods      map[int64]Food
+	nextFoodID int64
+
+	foodRevisions map[int64][]FoodRevision
+
+	users      map[int64]User
+	nextUserID int64
+
+	tokens map[string]Token
+
+	permissions      map[int64]string
+	usersPermissions map[int64]map[int64]bool
+
+	roles      map[int64]memoryRole
+	usersRoles map[int64]map[int64]bool
+
+	auditEvents []AuditEvent
+	nextAuditID int64
+}
+
+// memoryRole is a row of the roles table together with its roles_permissions.
+type memoryRole struct {
+	name        string
+	parentID    int64
+	permissions map[int64]bool
+}
+
+// Create the store with the same permission codes and roles the migrations insert.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		foods:         make(map[int64]Food),
+		nextFoodID:    1,
+		foodRevisions: make(map[int64][]FoodRevision),
+		users:         make(map[int64]User),
+		nextUserID:    1,
+		tokens:        make(map[string]Token),
+		permissions: map[int64]string{
+			1: "foods:read",
+			2: "foods:write",
+			3: "users:admin",
+			4: "debug:read",
+		},
+		usersPermissions: make(map[int64]map[int64]bool),
+		roles: map[int64]memoryRole{
+			1: {name: "viewer", permissions: map[int64]bool{1: true}},
+			2: {name: "editor", parentID: 1, permissions: map[int64]bool{2: true}},
+			3: {name: "admin", parentID: 2, permissions: map[int64]bool{3: true}},
+		},
+		usersRoles:  make(map[int64]map[int64]bool),
+		nextAuditID: 1,
+	}
+}
+
+// MemoryFoodModel implements FoodStore keeping the foods in memory.
+type MemoryFoodModel struct {
+	store *memoryStore
+}
+
+func (f MemoryFoodModel) Insert(food *Food, userID int64) error {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+
+	return f.store.insertFood(food, userID)
+}
+
+func (f MemoryFoodModel) Get(id int64) (*Food, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	f.store.mu.RLock()
+	defer f.store.mu.RUnlock()
+
+	food, ok := f.store.foods[id]
+	if !ok || food.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	food = copyFood(food)
+	return &food, nil
+}
+
+// GetAll mimics the SQL query of FoodModel.GetAll: full text match on the title (every
+// word must be present), overlap on the types, range filters, sorting with id as
+// tie-breaker and LIMIT/OFFSET pagination.
+func (f MemoryFoodModel) GetAll(title string, types []string, filters Filters) ([]*Food, Metadata, error) {
+	f.store.mu.RLock()
+	defer f.store.mu.RUnlock()
+
+	foods := f.filterFoods(title, types, filters)
+
+	totalRecords := len(foods)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return foods[start:end], metadata, nil
+}
+
+// GetAllByCursor mimics FoodModel.GetAllByCursor: the rows up to the cursor are skipped
+// and the page is taken from there (walking backwards for backward cursors, like the
+// reversed ORDER BY).
+func (f MemoryFoodModel) GetAllByCursor(title string, types []string, filters Filters) ([]*Food, CursorPage, error) {
+	f.store.mu.RLock()
+	defer f.store.mu.RUnlock()
+
+	foods := f.filterFoods(title, types, filters)
+	if filters.Cursor != nil && filters.Cursor.Backward {
+		slices.Reverse(foods)
+	}
+
+	rows := []*Food{}
+	for _, food := range foods {
+		if len(rows) > filters.limit() {
+			break
+		}
+		if filters.afterCursor(food.column) {
+			rows = append(rows, food)
+		}
+	}
+
+	rows, page := cursorPage(filters, rows, (*Food).column)
+
+	if filters.Count {
+		totalRecords := len(foods)
+		page.TotalRecords = &totalRecords
+	}
+
+	return rows, page, nil
+}
+
+// Return copies of the foods matching the title, types and ranges, sorted. The caller
+// must hold the lock.
+func (f MemoryFoodModel) filterFoods(title string, types []string, filters Filters) []*Food {
+	foods := []*Food{}
+	for _, food := range f.store.foods {
+		if food.DeletedAt != nil {
+			continue
+		}
+		if !matchesTitle(food.Title, title) || !overlaps(food.Types, types) || !filters.match(food.column) {
+			continue
+		}
+		food = copyFood(food)
+		foods = append(foods, &food)
+	}
+
+	sort.Slice(foods, func(i, j int) bool {
+		return filters.less(foods[i], foods[j], func(row interface{}, column string) interface{} {
+			return row.(*Food).column(column)
+		})
+	})
+
+	return foods
+}
+
+func (f MemoryFoodModel) Update(food *Food, userID int64) error {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+
+	return f.store.updateFood(food, userID)
+}
+
+func (f MemoryFoodModel) Delete(id int64, version int32) error {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+
+	return f.store.deleteFood(id, version)
+}
+
+func (f MemoryFoodModel) GetTrash(filters Filters) ([]*Food, Metadata, error) {
+	f.store.mu.RLock()
+	defer f.store.mu.RUnlock()
+
+	foods := []*Food{}
+	for _, food := range f.store.foods {
+		if food.DeletedAt == nil || !filters.match(food.column) {
+			continue
+		}
+		food = copyFood(food)
+		foods = append(foods, &food)
+	}
+
+	sort.Slice(foods, func(i, j int) bool {
+		return filters.less(foods[i], foods[j], func(row interface{}, column string) interface{} {
+			return row.(*Food).column(column)
+		})
+	})
+
+	totalRecords := len(foods)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return foods[start:end], metadata, nil
+}
+
+func (f MemoryFoodModel) Restore(id int64) error {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+
+	food, ok := f.store.foods[id]
+	if !ok || food.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+
+	food.DeletedAt = nil
+	f.store.foods[id] = food
+	return nil
+}
+
+func (f MemoryFoodModel) Purge(retention time.Duration) (int64, error) {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	var purged int64
+	for id, food := range f.store.foods {
+		if food.DeletedAt != nil && food.DeletedAt.Before(cutoff) {
+			delete(f.store.foods, id)
+			delete(f.store.foodRevisions, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (f MemoryFoodModel) GetRevisions(foodID int64, filters Filters) ([]*FoodRevision, Metadata, error) {
+	f.store.mu.RLock()
+	defer f.store.mu.RUnlock()
+
+	revisions := []*FoodRevision{}
+	for _, revision := range f.store.foodRevisions[foodID] {
+		revision = copyFoodRevision(revision)
+		revisions = append(revisions, &revision)
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return filters.less(revisions[i], revisions[j], func(row interface{}, column string) interface{} {
+			return row.(*FoodRevision).column(column)
+		})
+	})
+
+	totalRecords := len(revisions)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+	revisions = revisions[start:end]
+
+	err := setRevisionChanges(revisions, f.store.getFoodRevisions(foodID))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+func (f MemoryFoodModel) GetRevision(foodID int64, version int32) (*FoodRevision, error) {
+	f.store.mu.RLock()
+	defer f.store.mu.RUnlock()
+
+	found, _ := f.store.getFoodRevisions(foodID)([]int32{version})
+	revision, ok := found[version]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	err := setRevisionChanges([]*FoodRevision{revision}, f.store.getFoodRevisions(foodID))
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+// Bulk mimics the transaction of FoodModel.Bulk, the whole store is locked while the
+// operations run.
+func (f MemoryFoodModel) Bulk(ops []*FoodOperation, userID int64, atomic bool) error {
+	f.store.mu.Lock()
+	defer f.store.mu.Unlock()
+
+	// Keep the tables as they are to roll back, the revisions are only appended so the old
+	// slices still see the old revisions.
+	foods, revisions, nextFoodID := maps.Clone(f.store.foods), maps.Clone(f.store.foodRevisions), f.store.nextFoodID
+	saved := make([]Food, 0, len(ops))
+
+	for _, op := range ops {
+		saved = append(saved, *op.Food)
+
+		switch op.Action {
+		case BulkCreate:
+			op.Err = f.store.insertFood(op.Food, userID)
+		case BulkUpdate:
+			op.Err = f.store.updateFood(op.Food, userID)
+		case BulkDelete:
+			op.Err = f.store.deleteFood(op.Food.ID, op.Food.Version)
+		default:
+			op.Err = fmt.Errorf("unknown bulk action %q", op.Action)
+		}
+
+		// A failed operation writes nothing, so only the atomic mode has to roll back.
+		if op.Err != nil && atomic {
+			f.store.foods, f.store.foodRevisions, f.store.nextFoodID = foods, revisions, nextFoodID
+			resetFoodOperations(ops, saved)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// The writes of the foods, shared by the single and the bulk operations. The caller must
+// hold the lock.
+func (s *memoryStore) insertFood(food *Food, userID int64) error {
+	food.ID = s.nextFoodID
+	food.CreateAt = time.Now().UTC().Truncate(time.Second)
+	food.Version = 1
+	s.nextFoodID++
+
+	s.foods[food.ID] = copyFood(*food)
+	s.addFoodRevision(food, userID)
+	return nil
+}
+
+func (s *memoryStore) updateFood(food *Food, userID int64) error {
+	current, ok := s.foods[food.ID]
+	if !ok || current.DeletedAt != nil || current.Version != food.Version {
+		return ErrEditConflict
+	}
+
+	food.Version++
+	s.foods[food.ID] = copyFood(*food)
+	s.addFoodRevision(food, userID)
+	return nil
+}
+
+func (s *memoryStore) deleteFood(id int64, version int32) error {
+	food, ok := s.foods[id]
+	if id < 1 || !ok || food.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+	if food.Version != version {
+		return ErrEditConflict
+	}
+
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	food.DeletedAt = &deletedAt
+	s.foods[id] = food
+	return nil
+}
+
+// Save the food as a new revision, like the revision CTE of FoodModel.Insert and Update.
+// The caller must hold the lock.
+func (s *memoryStore) addFoodRevision(food *Food, userID int64) {
+	revision := FoodRevision{
+		Version:   food.Version,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Food:      copyFood(*food),
+	}
+	if userID != 0 {
+		revision.ChangedBy = &userID
+	}
+
+	s.foodRevisions[food.ID] = append(s.foodRevisions[food.ID], revision)
+}
+
+// Return a function that finds the given versions of the food, for setRevisionChanges().
+// The caller must hold the lock.
+func (s *memoryStore) getFoodRevisions(foodID int64) func(versions []int32) (map[int32]*FoodRevision, error) {
+	return func(versions []int32) (map[int32]*FoodRevision, error) {
+		found := make(map[int32]*FoodRevision)
+		for _, revision := range s.foodRevisions[foodID] {
+			if slices.Contains(versions, revision.Version) {
+				revision = copyFoodRevision(revision)
+				found[revision.Version] = &revision
+			}
+		}
+		return found, nil
+	}
+}
+
+// MemoryUserModel implements UserStore keeping the users in memory.
+type MemoryUserModel struct {
+	store *memoryStore
+}
+
+func (m MemoryUserModel) Insert(user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = m.store.nextUserID
+	user.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	user.Version = 1
+	m.store.nextUserID++
+
+	m.store.users[user.ID] = copyUser(*user)
+	return nil
+}
+
+func (m MemoryUserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	user, ok := m.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	user = copyUser(user)
+	return &user, nil
+}
+
+// GetAll mimics the SQL query of UserModel.GetAll.
+func (m MemoryUserModel) GetAll(name, email string, filters Filters) ([]*User, Metadata, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	users := []*User{}
+	for _, user := range m.store.users {
+		if !matchesTitle(user.Name, name) || !strings.Contains(strings.ToLower(user.Email), strings.ToLower(email)) {
+			continue
+		}
+		if !filters.match(user.column) {
+			continue
+		}
+		user = copyUser(user)
+		users = append(users, &user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return filters.less(users[i], users[j], func(row interface{}, column string) interface{} {
+			return row.(*User).column(column)
+		})
+	})
+
+	totalRecords := len(users)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users[start:end], metadata, nil
+}
+
+func (m MemoryUserModel) GetByEmail(email string) (*User, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) {
+			user = copyUser(user)
+			return &user, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m MemoryUserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := m.store.users[token.UserId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	user = copyUser(user)
+	return &user, nil
+}
+
+func (m MemoryUserModel) Update(user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	current, ok := m.store.users[user.ID]
+	if !ok || current.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if m.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	m.store.users[user.ID] = copyUser(*user)
+	return nil
+}
+
+// MemoryTokenModel implements TokenStore keeping the tokens in memory, indexed by hash.
+type MemoryTokenModel struct {
+	store *memoryStore
+}
+
+func (m MemoryTokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m MemoryTokenModel) Insert(token *Token) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// Behave like the foreign key on tokens.user_id.
+	if _, ok := m.store.users[token.UserId]; !ok {
+		return ErrRecordNotFound
+	}
+
+	stored := copyToken(*token)
+	stored.Plaintext = ""
+	m.store.tokens[string(token.Hash)] = stored
+	return nil
+}
+
+func (m MemoryTokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != scope {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.tokens, string(tokenHash[:]))
+	return nil
+}
+
+func (m MemoryTokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	now := time.Now()
+	tokens := []*Token{}
+
+	for _, token := range m.store.tokens {
+		if token.Scope != scope || token.UserId != userID || !token.Expiry.After(now) {
+			continue
+		}
+		token = copyToken(token)
+		tokens = append(tokens, &token)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (m MemoryTokenModel) UpdateLastUsed(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || token.LastUsedAt.Before(now.Add(-lastUsedResolution)) {
+		token.LastUsedAt = &now
+		m.store.tokens[string(tokenHash[:])] = token
+	}
+	return nil
+}
+
+func (m MemoryTokenModel) DeleteAllFromUser(scope string, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.Scope == scope && token.UserId == userID {
+			delete(m.store.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m MemoryTokenModel) RevokeAllFromUser(userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.UserId == userID {
+			delete(m.store.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// MemoryPermissionsModel implements PermissionStore keeping the users_permissions in memory.
+type MemoryPermissionsModel struct {
+	store *memoryStore
+}
+
+func (m MemoryPermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	var permissions Permissions
+
+	if _, ok := m.store.users[userID]; !ok {
+		return permissions, nil
+	}
+
+	codes := make(map[int64]bool)
+	for permissionID := range m.store.usersPermissions[userID] {
+		codes[permissionID] = true
+	}
+
+	// Follow every role of the user up through its parents. The visited set stops
+	// the walk on cycles, like the UNION of the recursive query.
+	visited := make(map[int64]bool)
+	for roleID := range m.store.usersRoles[userID] {
+		for roleID != 0 && !visited[roleID] {
+			visited[roleID] = true
+			role := m.store.roles[roleID]
+			for permissionID := range role.permissions {
+				codes[permissionID] = true
+			}
+			roleID = role.parentID
+		}
+	}
+
+	for permissionID := range codes {
+		permissions = append(permissions, m.store.permissions[permissionID])
+	}
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+func (m MemoryPermissionsModel) GetAll() (Permissions, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	var permissions Permissions
+	for _, code := range m.store.permissions {
+		permissions = append(permissions, code)
+	}
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+// AddForUser ignores unknown codes and codes the user already have, like the
+// INSERT ... SELECT ... ON CONFLICT DO NOTHING of PermissionsModel.
+func (m MemoryPermissionsModel) AddForUser(userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// Behave like the foreign key on users_permissions.user_id.
+	if _, ok := m.store.users[userID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	granted := m.store.usersPermissions[userID]
+	if granted == nil {
+		granted = make(map[int64]bool)
+		m.store.usersPermissions[userID] = granted
+	}
+
+	for id, code := range m.store.permissions {
+		for _, c := range codes {
+			if code == c {
+				granted[id] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (m MemoryPermissionsModel) RemoveForUser(userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for id := range m.store.usersPermissions[userID] {
+		for _, code := range codes {
+			if m.store.permissions[id] == code {
+				delete(m.store.usersPermissions[userID], id)
+			}
+		}
+	}
+	return nil
+}
+
+// MemoryRoleModel implements RoleStore keeping the roles and users_roles in memory.
+type MemoryRoleModel struct {
+	store *memoryStore
+}
+
+func (m MemoryRoleModel) GetAll() ([]*Role, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	roles := []*Role{}
+	for id, r := range m.store.roles {
+		role := &Role{ID: id, Name: r.name, Permissions: Permissions{}}
+		if parent, ok := m.store.roles[r.parentID]; ok {
+			role.Parent = parent.name
+		}
+		for permissionID := range r.permissions {
+			role.Permissions = append(role.Permissions, m.store.permissions[permissionID])
+		}
+		sort.Strings(role.Permissions)
+		roles = append(roles, role)
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].ID < roles[j].ID
+	})
+
+	return roles, nil
+}
+
+func (m MemoryRoleModel) GetAllForUser(userID int64) ([]string, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	names := []string{}
+	for roleID := range m.store.usersRoles[userID] {
+		names = append(names, m.store.roles[roleID].name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// AddForUser ignores unknown names and roles the user already have, like RoleModel.
+func (m MemoryRoleModel) AddForUser(userID int64, names ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// Behave like the foreign key on users_roles.user_id.
+	if _, ok := m.store.users[userID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	assigned := m.store.usersRoles[userID]
+	if assigned == nil {
+		assigned = make(map[int64]bool)
+		m.store.usersRoles[userID] = assigned
+	}
+
+	for id, role := range m.store.roles {
+		for _, name := range names {
+			if role.name == name {
+				assigned[id] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (m MemoryRoleModel) RemoveForUser(userID int64, names ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for id := range m.store.usersRoles[userID] {
+		for _, name := range names {
+			if m.store.roles[id].name == name {
+				delete(m.store.usersRoles[userID], id)
+			}
+		}
+	}
+	return nil
+}
+
+// MemoryAuditModel implements AuditStore keeping the audit events in memory.
+type MemoryAuditModel struct {
+	store *memoryStore
+}
+
+func (m MemoryAuditModel) Insert(event *AuditEvent) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	event.ID = m.store.nextAuditID
+	event.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if len(event.Changes) == 0 {
+		event.Changes = json.RawMessage("{}")
+	}
+	m.store.nextAuditID++
+
+	m.store.auditEvents = append(m.store.auditEvents, copyAuditEvent(*event))
+	return nil
+}
+
+func (m MemoryAuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	events := []*AuditEvent{}
+	for _, event := range m.store.auditEvents {
+		if filter.ActorID != 0 && (event.ActorID == nil || *event.ActorID != filter.ActorID) {
+			continue
+		}
+		if filter.ResourceType != "" && event.ResourceType != filter.ResourceType {
+			continue
+		}
+		if filter.ResourceID != 0 && event.ResourceID != filter.ResourceID {
+			continue
+		}
+		if !filters.match(event.column) {
+			continue
+		}
+		event = copyAuditEvent(event)
+		events = append(events, &event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return filters.less(events[i], events[j], func(row interface{}, column string) interface{} {
+			return row.(*AuditEvent).column(column)
+		})
+	})
+
+	totalRecords := len(events)
+	start := min(filters.offset(), totalRecords)
+	end := min(start+filters.limit(), totalRecords)
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return events[start:end], metadata, nil
+}
+
+// The caller must hold the lock. Emails are compared case insensitive like the citext column.
+func (s *memoryStore) emailTaken(email string, exceptID int64) bool {
+	for id, user := range s.users {
+		if id != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the value of a column of the foods table, for the range filters and sorting.
+func (food *Food) column(column string) interface{} {
+	switch column {
+	case "id":
+		return food.ID
+	case "title":
+		return food.Title
+	case "created_at":
+		return food.CreateAt
+	case "energy_kcal":
+		return food.Nutrition.EnergyKcal
+	case "protein":
+		return food.Nutrition.Protein
+	case "fat":
+		return food.Nutrition.Fat
+	case "saturated_fat":
+		return food.Nutrition.SaturatedFat
+	case "carbohydrates":
+		return food.Nutrition.Carbohydrates
+	case "sugar":
+		return food.Nutrition.Sugar
+	case "fibre":
+		return food.Nutrition.Fibre
+	case "salt":
+		return food.Nutrition.Salt
+	case "serving_size":
+		return food.ServingSize
+	case "deleted_at":
+		if food.DeletedAt == nil {
+			return time.Time{}
+		}
+		return *food.DeletedAt
+	default:
+		return nil
+	}
+}
+
+// Return the value of a column of the users table, for the range filters and sorting.
+func (user *User) column(column string) interface{} {
+	switch column {
+	case "id":
+		return user.ID
+	case "name":
+		return user.Name
+	case "email":
+		return strings.ToLower(user.Email)
+	case "created_at":
+		return user.CreatedAt
+	default:
+		return nil
+	}
+}
+
+// Return the value of a column of the food_revisions table, for sorting.
+func (revision *FoodRevision) column(column string) interface{} {
+	switch column {
+	case "version":
+		return int64(revision.Version)
+	default:
+		return nil
+	}
+}
+
+// Return the value of a column of the audit_events table, for the range filters and sorting.
+func (event *AuditEvent) column(column string) interface{} {
+	switch column {
+	case "id":
+		return event.ID
+	case "created_at":
+		return event.CreatedAt
+	default:
+		return nil
+	}
+}
+
+// Copy the slices so the callers never share memory with the store.
+func copyFood(food Food) Food {
+	if food.Types != nil {
+		food.Types = append([]string{}, food.Types...)
+	}
+	if food.DeletedAt != nil {
+		deletedAt := *food.DeletedAt
+		food.DeletedAt = &deletedAt
+	}
+	return food
+}
+
+func copyFoodRevision(revision FoodRevision) FoodRevision {
+	if revision.ChangedBy != nil {
+		changedBy := *revision.ChangedBy
+		revision.ChangedBy = &changedBy
+	}
+	revision.Food = copyFood(revision.Food)
+	revision.Changes = append(json.RawMessage{}, revision.Changes...)
+	return revision
+}
+
+func copyAuditEvent(event AuditEvent) AuditEvent {
+	if event.ActorID != nil {
+		actorID := *event.ActorID
+		event.ActorID = &actorID
+	}
+	event.Changes = append(json.RawMessage{}, event.Changes...)
+	return event
+}
+
+func copyToken(token Token) Token {
+	token.Hash = append([]byte{}, token.Hash...)
+	if token.LastUsedAt != nil {
+		lastUsedAt := *token.LastUsedAt
+		token.LastUsedAt = &lastUsedAt
+	}
+	return token
+}
+
+func copyUser(user User) User {
+	if user.Password.hash != nil {
+		user.Password.hash = append([]byte{}, user.Password.hash...)
+	}
+	user.Password.plaintext = nil
+	return user
+}
+
+// matchesTitle approximates to_tsvector('simple', title) @@ plainto_tsquery('simple', query):
+// every word of the query must be a word of the title, ignoring case.
+func matchesTitle(title, query string) bool {
+	queryWords := tsWords(query)
+	if len(queryWords) == 0 {
+		return true
+	}
+
+	titleWords := make(map[string]bool)
+	for _, word := range tsWords(title) {
+		titleWords[word] = true
+	}
+
+	for _, word := range queryWords {
+		if !titleWords[word] {
+			return false
+		}
+	}
+	return true
+}
+
+func tsWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// overlaps behaves like the && array operator, an empty filter matches everything.
+func overlaps(values, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, value := range values {
+		for _, f := range filter {
+			if value == f {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // Define a custom ErrorRecordNotFound error. We'll return this from our
@@ -12,13 +13,75 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
-// Create models struct which wraps the FoodModel. We'll add another models to this
-// like UserModel or PermissionModel, as our build progresses.
+// FoodStore describes the operations the handlers need over the foods table. FoodModel
+// implements it on top of PostgreSQL and MemoryFoodModel keeps everything in memory.
+type FoodStore interface {
+	Insert(food *Food, userID int64) error
+	Get(id int64) (*Food, error)
+	GetAll(title string, types []string, filters Filters) ([]*Food, Metadata, error)
+	GetAllByCursor(title string, types []string, filters Filters) ([]*Food, CursorPage, error)
+	Update(food *Food, userID int64) error
+	Delete(id int64, version int32) error
+	GetRevisions(foodID int64, filters Filters) ([]*FoodRevision, Metadata, error)
+	GetRevision(foodID int64, version int32) (*FoodRevision, error)
+	GetTrash(filters Filters) ([]*Food, Metadata, error)
+	Restore(id int64) error
+	Purge(retention time.Duration) (int64, error)
+	Bulk(ops []*FoodOperation, userID int64, atomic bool) error
+}
+
+// UserStore describes the operations over the users table.
+type UserStore interface {
+	Insert(user *User) error
+	Get(id int64) (*User, error)
+	GetAll(name, email string, filters Filters) ([]*User, Metadata, error)
+	GetByEmail(email string) (*User, error)
+	GetForToken(tokenScope, tokenPlaintext string) (*User, error)
+	Update(user *User) error
+}
+
+// TokenStore describes the operations over the tokens table.
+type TokenStore interface {
+	New(userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(token *Token) error
+	Delete(scope, tokenPlaintext string) error
+	GetAllForUser(scope string, userID int64) ([]*Token, error)
+	UpdateLastUsed(tokenPlaintext string) error
+	DeleteAllFromUser(scope string, userID int64) error
+	RevokeAllFromUser(userID int64) error
+}
+
+// PermissionStore describes the operations over the permissions and users_permissions tables.
+type PermissionStore interface {
+	GetAll() (Permissions, error)
+	GetAllForUser(userID int64) (Permissions, error)
+	AddForUser(userID int64, codes ...string) error
+	RemoveForUser(userID int64, codes ...string) error
+}
+
+// RoleStore describes the operations over the roles and users_roles tables.
+type RoleStore interface {
+	GetAll() ([]*Role, error)
+	GetAllForUser(userID int64) ([]string, error)
+	AddForUser(userID int64, names ...string) error
+	RemoveForUser(userID int64, names ...string) error
+}
+
+// AuditStore describes the operations over the audit_events table.
+type AuditStore interface {
+	Insert(event *AuditEvent) error
+	GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error)
+}
+
+// Create models struct which wraps the stores. Every field is an interface so the
+// handlers don't care if the data lives in PostgreSQL or in memory.
 type Models struct {
-	Foods       FoodModel
-	Users       UserModel
-	Token       TokenModel
-	Permissions PermissionsModel
+	Foods       FoodStore
+	Users       UserStore
+	Token       TokenStore
+	Permissions PermissionStore
+	Roles       RoleStore
+	Audit       AuditStore
 }
 
 // For ease of use, we also add a New() method which return a Models struct constaining
@@ -29,11 +92,22 @@ func NewModels(db *sql.DB) Models {
 		Users:       UserModel{DB: db},
 		Token:       TokenModel{DB: db},
 		Permissions: PermissionsModel{DB: db},
+		Roles:       RoleModel{DB: db},
+		Audit:       AuditModel{DB: db},
 	}
 }
 
-// func NewModelsMock() Models {
-// 	return Models{
-// 		Foods: MockFoodModel{},
-// 	}
-// }
+// NewMemoryModels returns a Models struct backed by a single in-memory store, so the
+// whole application can run without PostgreSQL (tests, local demos, etc...).
+func NewMemoryModels() Models {
+	store := newMemoryStore()
+
+	return Models{
+		Foods:       MemoryFoodModel{store: store},
+		Users:       MemoryUserModel{store: store},
+		Token:       MemoryTokenModel{store: store},
+		Permissions: MemoryPermissionsModel{store: store},
+		Roles:       MemoryRoleModel{store: store},
+		Audit:       MemoryAuditModel{store: store},
+	}
+}
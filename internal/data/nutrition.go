@@ -0,0 +1,44 @@
+package data
+
+import (
+	"SrbastianM/rest-api-gin/internal/validator"
+)
+
+// Nutrition profile per 100g of food. Energy is in kcal, everything else in grams.
+type Nutrition struct {
+	EnergyKcal    float64 `json:"energy_kcal"`
+	Protein       float64 `json:"protein"`
+	Fat           float64 `json:"fat"`
+	SaturatedFat  float64 `json:"saturated_fat"`
+	Carbohydrates float64 `json:"carbohydrates"`
+	Sugar         float64 `json:"sugar"`
+	Fibre         float64 `json:"fibre"`
+	Salt          float64 `json:"salt"`
+}
+
+func ValidateNutrition(v *validator.Validator, n Nutrition) {
+	v.Check(n.EnergyKcal >= 0, "nutrition.energy_kcal", "must not be negative")
+	// Pure fat has 900 kcal per 100g, nothing can have more.
+	v.Check(n.EnergyKcal <= 900, "nutrition.energy_kcal", "must not be more than 900")
+
+	grams := []struct {
+		key   string
+		value float64
+	}{
+		{"nutrition.protein", n.Protein},
+		{"nutrition.fat", n.Fat},
+		{"nutrition.saturated_fat", n.SaturatedFat},
+		{"nutrition.carbohydrates", n.Carbohydrates},
+		{"nutrition.sugar", n.Sugar},
+		{"nutrition.fibre", n.Fibre},
+		{"nutrition.salt", n.Salt},
+	}
+	for _, g := range grams {
+		v.Check(g.value >= 0, g.key, "must not be negative")
+		v.Check(g.value <= 100, g.key, "must not be more than 100 grams")
+	}
+
+	v.Check(n.SaturatedFat <= n.Fat, "nutrition.saturated_fat", "must not be more than fat")
+	v.Check(n.Sugar <= n.Carbohydrates, "nutrition.sugar", "must not be more than carbohydrates")
+	v.Check(n.Protein+n.Fat+n.Carbohydrates+n.Fibre+n.Salt <= 100, "nutrition", "must not add up to more than 100 grams")
+}
@@ -23,13 +23,31 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// Return the effective permissions of the user: the codes granted directly in
+// users_permissions plus the codes of every role of the user, following the role
+// inheritance up to the top. UNION (not UNION ALL) stops the recursion on cycles.
 func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
+	WITH RECURSIVE user_roles AS (
+		SELECT roles.id, roles.parent_id
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		UNION
+		SELECT roles.id, roles.parent_id
+		FROM roles
+		INNER JOIN user_roles ON roles.id = user_roles.parent_id
+	)
 	SELECT permissions.code
 	FROM permissions
 	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
 	INNER JOIN users ON users_permissions.user_id = users.id
 	WHERE users.id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+	INNER JOIN user_roles ON user_roles.id = roles_permissions.role_id
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -60,14 +78,65 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
+// Return every permission code known by the API.
+func (m PermissionsModel) GetAll() (Permissions, error) {
+	query := `SELECT code FROM permissions ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
 func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 	INSERT INTO users_permissions
 	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// Revoke permission codes granted directly to the user. Codes the user gets through a
+// role are not affected.
+func (m PermissionsModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.QueryContext(ctx, query, userID, pq.Array(codes))
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
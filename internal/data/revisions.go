@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FoodRevision is the food as it was at one version, who changed it (nil if nobody was
+// authenticated) and the fields that changed since the previous version.
+type FoodRevision struct {
+	Version   int32           `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	ChangedBy *int64          `json:"changed_by"`
+	Food      Food            `json:"food"`
+	Changes   json.RawMessage `json:"changes"`
+}
+
+// RevisionSchema declares the sort keys of the revisions listing.
+var RevisionSchema = Schema{
+	{Column: "version", Kind: KindNumber, Sort: "version"},
+}
+
+// The fields of a food that make its history, the id, creation date and version are the
+// same for every revision (or always change).
+func revisionFields(food *Food) interface{} {
+	if food == nil {
+		return nil
+	}
+	return struct {
+		Title       string
+		Types       []string
+		Nutrition   Nutrition
+		ServingSize float64
+	}{food.Title, food.Types, food.Nutrition, food.ServingSize}
+}
+
+// Fill the Changes of every revision comparing it with the previous version. The previous
+// versions that aren't in revisions are asked to previous(), the oldest revision known of
+// a food has every field as a change.
+func setRevisionChanges(revisions []*FoodRevision, previous func(versions []int32) (map[int32]*FoodRevision, error)) error {
+	byVersion := make(map[int32]*FoodRevision)
+	for _, revision := range revisions {
+		byVersion[revision.Version] = revision
+	}
+
+	var missing []int32
+	for _, revision := range revisions {
+		if _, ok := byVersion[revision.Version-1]; !ok && revision.Version > 1 {
+			missing = append(missing, revision.Version-1)
+		}
+	}
+
+	if len(missing) > 0 {
+		found, err := previous(missing)
+		if err != nil {
+			return err
+		}
+		for version, revision := range found {
+			byVersion[version] = revision
+		}
+	}
+
+	for _, revision := range revisions {
+		var before *Food
+		if prev, ok := byVersion[revision.Version-1]; ok {
+			before = &prev.Food
+		}
+
+		changes, err := AuditChanges(revisionFields(before), revisionFields(&revision.Food))
+		if err != nil {
+			return err
+		}
+		revision.Changes = changes
+	}
+
+	return nil
+}
+
+// The revision columns in the same order as scanRevision().
+const revisionColumns = `version, created_at, changed_by, food_id, (SELECT created_at FROM foods WHERE foods.id = food_id), title, type, ` + nutritionColumns
+
+func scanRevision(scan func(dest ...interface{}) error, extra ...interface{}) (*FoodRevision, error) {
+	var revision FoodRevision
+	food := &revision.Food
+
+	dest := append(extra, &revision.Version, &revision.CreatedAt, &revision.ChangedBy, &food.ID, &food.CreateAt, &food.Title, pq.Array(&food.Types))
+	err := scan(append(dest, nutritionDest(food)...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	food.Version = revision.Version
+	return &revision, nil
+}
+
+// Return the revisions of the food, with what changed in each one.
+func (f FoodModel) GetRevisions(foodID int64, filters Filters) ([]*FoodRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM food_revisions
+	WHERE food_id = $1
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, revisionColumns, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := f.DB.QueryContext(ctx, query, foodID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*FoodRevision{}
+
+	for rows.Next() {
+		revision, err := scanRevision(rows.Scan, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = setRevisionChanges(revisions, func(versions []int32) (map[int32]*FoodRevision, error) {
+		return f.getRevisions(foodID, versions)
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+// Return one revision of the food, with what changed since the previous version.
+func (f FoodModel) GetRevision(foodID int64, version int32) (*FoodRevision, error) {
+	found, err := f.getRevisions(foodID, []int32{version})
+	if err != nil {
+		return nil, err
+	}
+
+	revision, ok := found[version]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	err = setRevisionChanges([]*FoodRevision{revision}, func(versions []int32) (map[int32]*FoodRevision, error) {
+		return f.getRevisions(foodID, versions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+func (f FoodModel) getRevisions(foodID int64, versions []int32) (map[int32]*FoodRevision, error) {
+	query := `SELECT ` + revisionColumns + ` FROM food_revisions WHERE food_id = $1 AND version = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := make([]int64, len(versions))
+	for i, version := range versions {
+		args[i] = int64(version)
+	}
+
+	rows, err := f.DB.QueryContext(ctx, query, foodID, pq.Array(args))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[int32]*FoodRevision)
+	for rows.Next() {
+		revision, err := scanRevision(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		found[revision.Version] = revision
+	}
+
+	return found, rows.Err()
+}
+
+// Use NULL for the changes made by nobody (the id of the anonymous user is 0).
+func nullUserID(userID int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: userID, Valid: userID != 0}
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A Role bundles permission codes. Parent is the name of the role it inherits from (empty if
+// none) and Permissions are only the codes granted to the role itself, not the inherited ones.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Parent      string      `json:"parent,omitempty"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// Return all the roles with their own permission codes.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+	SELECT roles.id, roles.name, COALESCE(parents.name, ''),
+		COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN roles parents ON parents.id = roles.parent_id
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+	GROUP BY roles.id, parents.name
+	ORDER BY roles.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.ID, &role.Name, &role.Parent, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Return the names of the roles assigned to the user (without the inherited ones).
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+	SELECT roles.name
+	FROM roles
+	INNER JOIN users_roles ON users_roles.role_id = roles.id
+	WHERE users_roles.user_id = $1
+	ORDER BY roles.name
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// Assign the roles to the user. Unknown names and roles the user already have are ignored.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+// Remove the roles from the user.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+	DELETE FROM users_roles
+	USING roles
+	WHERE users_roles.role_id = roles.id AND users_roles.user_id = $1 AND roles.name = ANY($2)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
@@ -11,15 +11,23 @@ import (
 )
 
 const (
-	ScopeActivation = "activation"
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
 )
 
+// Don't update last_used_at more than once per this interval, otherwise every
+// authenticated request would be a write to the tokens table.
+const lastUsedResolution = time.Minute
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserId    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext  string     `json:"token,omitempty"`
+	Hash       []byte     `json:"-"`
+	UserId     int64      `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Scope      string     `json:"-"`
 }
 
 type TokenModel struct {
@@ -43,12 +51,13 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO tokens (hash, user_id, created_at, expiry, scope)
+		VALUES ($1, $2, $3, $4, $5)
 	`
 	args := []interface{}{
 		token.Hash,
 		token.UserId,
+		token.CreatedAt,
 		token.Expiry,
 		token.Scope,
 	}
@@ -72,12 +81,120 @@ func (m TokenModel) DeleteAllFromUser(scope string, userID int64) error {
 	return err
 }
 
+// Delete a single token (logout of the current session). Return ErrRecordNotFound if
+// there isn't any token with the given scope and plaintext.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Return the tokens of the user for the given scope that haven't expired yet, newest first.
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	query := `
+		SELECT hash, user_id, created_at, expiry, last_used_at, scope
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3
+		ORDER BY created_at DESC
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var (
+			token      Token
+			lastUsedAt sql.NullTime
+		)
+
+		err := rows.Scan(&token.Hash, &token.UserId, &token.CreatedAt, &token.Expiry, &lastUsedAt, &token.Scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Record that the token was just used. The update is skipped if it was already recorded
+// in the last minute.
+func (m TokenModel) UpdateLastUsed(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = $2
+		WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < $3)
+	`
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], now, now.Add(-lastUsedResolution))
+	return err
+}
+
+// Delete every token of the user, whatever the scope. Used when the password changes so
+// any outstanding token stops working.
+func (m TokenModel) RevokeAllFromUser(userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a token instance wich contains the userID, expiry and the scope information
+	now := time.Now()
 	token := &Token{
-		UserId: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserId:    userID,
+		CreatedAt: now,
+		Expiry:    now.Add(ttl),
+		Scope:     scope,
 	}
 
 	// Initialize a zero-valued byte slice with a length of 16 bytes
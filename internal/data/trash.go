@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TrashSchema declares the sort keys and range filters of the trash listing.
+var TrashSchema = Schema{
+	{Column: "id", Kind: KindNumber, Sort: "id"},
+	{Column: "title", Kind: KindText, Sort: "title"},
+	{Column: "deleted_at", Kind: KindTime, Sort: "deleted_at", MinParam: "deleted_after", MaxParam: "deleted_before", Strict: true},
+}
+
+// Return the foods in the trash.
+func (f FoodModel) GetTrash(filters Filters) ([]*Food, Metadata, error) {
+	args := []interface{}{filters.limit(), filters.offset()}
+	rangesWhere, args := filters.where(args)
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, type, %s, version, deleted_at
+	FROM foods
+	WHERE deleted_at IS NOT NULL%s
+	ORDER BY %s
+	LIMIT $1 OFFSET $2`, nutritionColumns, rangesWhere, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := f.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	foods := []*Food{}
+
+	for rows.Next() {
+		var food Food
+		dest := append([]interface{}{&totalRecords, &food.ID, &food.CreateAt, &food.Title, pq.Array(&food.Types)}, nutritionDest(&food)...)
+		err := rows.Scan(append(dest, &food.Version, &food.DeletedAt)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		foods = append(foods, &food)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return foods, metadata, nil
+}
+
+// Take the food out of the trash. It returns ErrRecordNotFound if the food isn't in the
+// trash (it was never deleted or it's already purged).
+func (f FoodModel) Restore(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `UPDATE foods SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := f.DB.QueryRowContext(ctx, query, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete for good the foods that are in the trash for longer than retention, with their
+// revisions. It returns how many foods were deleted.
+func (f FoodModel) Purge(retention time.Duration) (int64, error) {
+	query := `DELETE FROM foods WHERE deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := f.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/jackc/pgconn"
@@ -24,6 +25,14 @@ type User struct {
 	Version   int
 }
 
+// UserSchema declares the sort keys and range filters of the users listing.
+var UserSchema = Schema{
+	{Column: "id", Kind: KindNumber, Sort: "id"},
+	{Column: "name", Kind: KindText, Sort: "name"},
+	{Column: "email", Kind: KindText, Sort: "email"},
+	{Column: "created_at", Kind: KindTime, Sort: "created_at", MinParam: "created_after", MaxParam: "created_before", Strict: true},
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -124,6 +133,95 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Retrieve the user details from the database based on the user's ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Retrieve a page of users, searching by name (full text) and by a fragment of the email.
+func (m UserModel) GetAll(name, email string, filters Filters) ([]*User, Metadata, error) {
+	args := []interface{}{name, email, filters.limit(), filters.offset()}
+	rangesWhere, args := filters.where(args)
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')%s
+	ORDER BY %s
+	LIMIT $3 OFFSET $4`, rangesWhere, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 // Retrieve the user details form the database based on the user's email address.
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
@@ -3,7 +3,7 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"log"
+	"fmt"
 	"text/template"
 	"time"
 
@@ -31,10 +31,10 @@ func New(host string, port int, username, passwror, sender string) Mailer {
 }
 
 func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	// The callers log the error (with the request ID), so it only needs the template name.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		log.Println("Error loading the template", err)
-		return err
+		return fmt.Errorf("loading the template %s: %w", templateFile, err)
 	}
 
 	subject := new(bytes.Buffer)
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(r *Registry)
+		want  string
+	}{
+		{
+			name:  "counter without labels starts at zero",
+			build: func(r *Registry) { r.NewCounterVec("jobs_total", "Jobs run.") },
+			want: `# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total 0
+`,
+		},
+		{
+			name: "counter with labels sorted by value",
+			build: func(r *Registry) {
+				c := r.NewCounterVec("requests_total", "Requests.", "method", "status")
+				c.Inc("POST", "201")
+				c.Add(2.5, "GET", "200")
+				c.Inc("GET", "200")
+			},
+			want: `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 3.5
+requests_total{method="POST",status="201"} 1
+`,
+		},
+		{
+			name: "label escaping",
+			build: func(r *Registry) {
+				c := r.NewCounterVec("odd_total", "Odd labels.", "value")
+				c.Inc(`say "hi"`)
+				c.Inc(`C:\temp`)
+				c.Inc("two\nlines")
+			},
+			want: `# HELP odd_total Odd labels.
+# TYPE odd_total counter
+odd_total{value="C:\\temp"} 1
+odd_total{value="say \"hi\""} 1
+odd_total{value="two\nlines"} 1
+`,
+		},
+		{
+			name: "missing label values are empty",
+			build: func(r *Registry) {
+				r.NewCounterVec("partial_total", "Partial.", "a", "b").Inc("x")
+			},
+			want: `# HELP partial_total Partial.
+# TYPE partial_total counter
+partial_total{a="x",b=""} 1
+`,
+		},
+		{
+			name:  "help escaping",
+			build: func(r *Registry) { r.NewGauge("g", "A \\ backslash\nand a \"quote\"") },
+			want: `# HELP g A \\ backslash\nand a "quote"
+# TYPE g gauge
+g 0
+`,
+		},
+		{
+			name: "gauge",
+			build: func(r *Registry) {
+				g := r.NewGauge("in_flight", "In flight.")
+				g.Add(3)
+				g.Add(-1)
+			},
+			want: `# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 2
+`,
+		},
+		{
+			name: "histogram",
+			build: func(r *Registry) {
+				h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.25, 1}, "route")
+				h.Observe(0.25, "/b") // The upper bound is inclusive
+				h.Observe(0.5, "/b")
+				h.Observe(4, "/b")
+				h.Observe(0.1, "/a")
+			},
+			want: `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.25"} 1
+latency_seconds_bucket{route="/a",le="1"} 1
+latency_seconds_bucket{route="/a",le="+Inf"} 1
+latency_seconds_sum{route="/a"} 0.1
+latency_seconds_count{route="/a"} 1
+latency_seconds_bucket{route="/b",le="0.25"} 1
+latency_seconds_bucket{route="/b",le="1"} 2
+latency_seconds_bucket{route="/b",le="+Inf"} 3
+latency_seconds_sum{route="/b"} 4.75
+latency_seconds_count{route="/b"} 3
+`,
+		},
+		{
+			name: "histogram without labels",
+			build: func(r *Registry) {
+				r.NewHistogramVec("size_bytes", "Size.", []float64{100}).Observe(1000)
+			},
+			want: `# HELP size_bytes Size.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="100"} 0
+size_bytes_bucket{le="+Inf"} 1
+size_bytes_sum 1000
+size_bytes_count 1
+`,
+		},
+		{
+			name: "func metrics in registration order, read when written",
+			build: func(r *Registry) {
+				open := 1.0
+				r.NewGaugeFunc("db_open", "Open.", func() float64 { return open })
+				r.NewCounterVec("between_total", "Between.")
+				r.NewCounterFunc("db_wait_total", "Waits.", func() float64 { return math.Inf(1) })
+				r.NewGaugeFunc("a_first_alphabetically", "A.", func() float64 { return -0.5 })
+				open = 7
+			},
+			want: `# HELP db_open Open.
+# TYPE db_open gauge
+db_open 7
+# HELP between_total Between.
+# TYPE between_total counter
+between_total 0
+# HELP db_wait_total Waits.
+# TYPE db_wait_total counter
+db_wait_total +Inf
+# HELP a_first_alphabetically A.
+# TYPE a_first_alphabetically gauge
+a_first_alphabetically -0.5
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.build(r)
+
+			var b strings.Builder
+			if err := r.WriteText(&b); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("up", "Up.").Add(1)
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	if want := "# HELP up Up.\n# TYPE up gauge\nup 1\n"; rr.Body.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", rr.Body.String(), want)
+	}
+}
@@ -0,0 +1,358 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed "sql"
+var migrationFS embed.FS
+
+// Migration files are named <version>_<name>.<up|down>.sql, the same layout used by
+// the migrate CLI so the files can still be applied by hand.
+var fileRx = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Arbitrary key for pg_advisory_lock(), it prevents two instances starting at the same
+// time from running the migrations concurrently.
+const lockKey = 729140155
+
+var (
+	ErrNoChange       = errors.New("no change")
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a single migration and when it was applied (zero if it's pending).
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	migrations []Migration
+}
+
+// Return a Migrator with the migrations embedded in the binary, sorted by version.
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := load(migrationFS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRx.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(fsys, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has two different names: %q and %q", version, m.Name, matches[2])
+		}
+
+		switch matches[3] {
+		case "up":
+			m.Up = string(content)
+		case "down":
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Latest returns the highest version known by the binary.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() error {
+	return m.Goto(m.Latest())
+}
+
+// Down rolls back the last n applied migrations.
+func (m *Migrator) Down(n int) error {
+	if n < 1 {
+		return errors.New("must roll back at least one migration")
+	}
+
+	var target int64
+
+	err := m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		if len(versions) == 0 {
+			return ErrNoChange
+		}
+		if n < len(versions) {
+			target = versions[n]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.Goto(target)
+}
+
+// Goto migrates the database up or down until the given version is the latest applied
+// one. Version 0 means rolling back every migration.
+func (m *Migrator) Goto(version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		err = m.checkApplied(applied)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+
+		// Roll back newest first everything above the target...
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= version || applied[migration.Version].IsZero() {
+				continue
+			}
+			err := m.run(conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			changed = true
+		}
+
+		// ...and then apply oldest first everything pending up to the target.
+		for _, migration := range m.migrations {
+			if migration.Version > version || !applied[migration.Version].IsZero() {
+				continue
+			}
+			err := m.run(conn, migration.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			changed = true
+		}
+
+		if !changed {
+			return ErrNoChange
+		}
+		return nil
+	})
+}
+
+// Status returns every known migration with the time it was applied.
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			statuses = append(statuses, Status{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: applied[migration.Version],
+			})
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Refuse to migrate a database with versions applied that the binary doesn't know, it
+// was migrated by a newer binary and rolling back or applying anything could break it.
+func (m *Migrator) checkApplied(applied map[int64]time.Time) error {
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	for _, version := range versions {
+		if m.find(version) == nil {
+			return fmt.Errorf("%w: %d is applied in the database but not in this binary", ErrUnknownVersion, version)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// Run the migration script and the bookkeeping query in the same transaction, so a
+// failing migration never leaves the schema_migrations table out of sync.
+func (m *Migrator) run(conn *sql.Conn, script, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Return the applied versions and when they were applied.
+func (m *Migrator) applied(conn *sql.Conn) (map[int64]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// Take a dedicated connection, hold the advisory lock on it while fn runs and make sure
+// the schema_migrations table exists.
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
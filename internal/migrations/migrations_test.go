@@ -0,0 +1,192 @@
+package migrations
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content)}
+	}
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "pairs and sorts by version",
+			fsys: fstest.MapFS{
+				"sql/000010_add_index.down.sql":    file("DROP INDEX i;"),
+				"sql/000002_create_users.up.sql":   file("CREATE TABLE users;"),
+				"sql/000010_add_index.up.sql":      file("CREATE INDEX i;"),
+				"sql/000002_create_users.down.sql": file("DROP TABLE users;"),
+				"sql/1_create_foods.up.sql":        file("CREATE TABLE foods;"),
+				"sql/1_create_foods.down.sql":      file("DROP TABLE foods;"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "create_foods", Up: "CREATE TABLE foods;", Down: "DROP TABLE foods;"},
+				{Version: 2, Name: "create_users", Up: "CREATE TABLE users;", Down: "DROP TABLE users;"},
+				{Version: 10, Name: "add_index", Up: "CREATE INDEX i;", Down: "DROP INDEX i;"},
+			},
+		},
+		{
+			name: "no migrations",
+			fsys: fstest.MapFS{"sql": &fstest.MapFile{Mode: fs.ModeDir | 0o755}},
+			want: []Migration{},
+		},
+		{
+			name:    "no sql directory",
+			fsys:    fstest.MapFS{"000001_create_foods.up.sql": file("CREATE TABLE foods;")},
+			wantErr: true,
+		},
+		{
+			name: "missing down file",
+			fsys: fstest.MapFS{
+				"sql/000001_create_foods.up.sql": file("CREATE TABLE foods;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing up file",
+			fsys: fstest.MapFS{
+				"sql/000001_create_foods.down.sql": file("DROP TABLE foods;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty up file",
+			fsys: fstest.MapFS{
+				"sql/000001_create_foods.up.sql":   file(""),
+				"sql/000001_create_foods.down.sql": file("DROP TABLE foods;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "two names for a version",
+			fsys: fstest.MapFS{
+				"sql/000001_create_foods.up.sql":   file("CREATE TABLE foods;"),
+				"sql/000001_create_meals.down.sql": file("DROP TABLE meals;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "file without a version",
+			fsys: fstest.MapFS{
+				"sql/create_foods.up.sql":   file("CREATE TABLE foods;"),
+				"sql/create_foods.down.sql": file("DROP TABLE foods;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "file without a direction",
+			fsys: fstest.MapFS{
+				"sql/000001_create_foods.sql": file("CREATE TABLE foods;"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "file that isn't SQL",
+			fsys: fstest.MapFS{
+				"sql/000001_create_foods.up.sql":   file("CREATE TABLE foods;"),
+				"sql/000001_create_foods.down.sql": file("DROP TABLE foods;"),
+				"sql/README.md":                    file("# Migrations"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "version out of range",
+			fsys: fstest.MapFS{
+				"sql/99999999999999999999_create_foods.up.sql":   file("CREATE TABLE foods;"),
+				"sql/99999999999999999999_create_foods.down.sql": file("DROP TABLE foods;"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := load(tt.fsys)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The migrations shipped in the binary must load, with the versions in a row.
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := load(migrationFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %d_%s, want version %d", m.Version, m.Name, i+1)
+		}
+	}
+}
+
+func TestCheckApplied(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 1}, {Version: 2}, {Version: 3}}}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		applied []int64
+		wantErr bool
+	}{
+		{"nothing applied", nil, false},
+		{"some applied", []int64{1, 2}, false},
+		{"every one applied", []int64{1, 2, 3}, false},
+		{"newer version applied", []int64{1, 2, 3, 4}, true},
+		{"unknown version in between", []int64{1, 7, 3}, true},
+		{"only an unknown version", []int64{42}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied := make(map[int64]time.Time)
+			for _, version := range tt.applied {
+				applied[version] = now
+			}
+
+			err := m.checkApplied(applied)
+			if tt.wantErr && !errors.Is(err, ErrUnknownVersion) {
+				t.Errorf("got %v, want ErrUnknownVersion", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// Goto checks the target before it touches the database.
+func TestGotoUnknownVersion(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 1}, {Version: 2}}}
+
+	for _, version := range []int64{-1, 3, 42} {
+		if err := m.Goto(version); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Goto(%d) = %v, want ErrUnknownVersion", version, err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS foods (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    type text[] NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS foods_title_idx;
+DROP INDEX IF EXISTS foods_type_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS foods_title_idx ON foods USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS foods_type_idx ON foods USING GIN (type);
@@ -0,0 +1,28 @@
+-- A role bundles permission codes and can inherit every permission of its parent role.
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL,
+    parent_id bigint REFERENCES roles ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- viewer -> editor -> admin, every role inherits the permissions of the previous one.
+INSERT INTO roles (name) VALUES ('viewer');
+INSERT INTO roles (name, parent_id) SELECT 'editor', id FROM roles WHERE name = 'viewer';
+INSERT INTO roles (name, parent_id) SELECT 'admin', id FROM roles WHERE name = 'editor';
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'foods:read')
+OR (roles.name = 'editor' AND permissions.code = 'foods:write');
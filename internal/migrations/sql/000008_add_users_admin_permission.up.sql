@@ -0,0 +1,5 @@
+INSERT INTO permissions (code) VALUES ('users:admin');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:admin';
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS foods_energy_kcal_idx;
+DROP INDEX IF EXISTS foods_protein_idx;
+
+ALTER TABLE foods
+    DROP COLUMN IF EXISTS energy_kcal,
+    DROP COLUMN IF EXISTS protein,
+    DROP COLUMN IF EXISTS fat,
+    DROP COLUMN IF EXISTS saturated_fat,
+    DROP COLUMN IF EXISTS carbohydrates,
+    DROP COLUMN IF EXISTS sugar,
+    DROP COLUMN IF EXISTS fibre,
+    DROP COLUMN IF EXISTS salt,
+    DROP COLUMN IF EXISTS serving_size;
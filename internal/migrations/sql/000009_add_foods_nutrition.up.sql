@@ -0,0 +1,14 @@
+-- Nutrition profile per 100g of food, serving size in grams (0 means unknown).
+ALTER TABLE foods
+    ADD COLUMN IF NOT EXISTS energy_kcal double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS protein double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS fat double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS saturated_fat double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS carbohydrates double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS sugar double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS fibre double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS salt double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS serving_size double precision NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS foods_energy_kcal_idx ON foods (energy_kcal);
+CREATE INDEX IF NOT EXISTS foods_protein_idx ON foods (protein);
@@ -0,0 +1,10 @@
+-- The counters are rebuilt in a few seconds, so the table doesn't need the WAL.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key text NOT NULL,
+    window_start timestamp with time zone NOT NULL,
+    count integer NOT NULL DEFAULT 0,
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_expires_at_idx ON rate_limits (expires_at);
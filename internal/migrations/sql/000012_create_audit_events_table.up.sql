@@ -0,0 +1,17 @@
+-- One row per write operation. The actor is null when nobody is authenticated (like a
+-- registration) and changes has the fields that changed, {"field": {"before": .., "after": ..}}.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    resource_type text NOT NULL,
+    resource_id bigint NOT NULL,
+    changes jsonb NOT NULL DEFAULT '{}',
+    client_ip text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_resource_idx ON audit_events (resource_type, resource_id, created_at);
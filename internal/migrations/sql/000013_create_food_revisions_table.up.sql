@@ -0,0 +1,27 @@
+-- A snapshot of the food at every version, written by the same statement that inserts or
+-- updates the food. changed_by is null when nobody was authenticated.
+CREATE TABLE IF NOT EXISTS food_revisions (
+    id bigserial PRIMARY KEY,
+    food_id bigint NOT NULL REFERENCES foods ON DELETE CASCADE,
+    version integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    changed_by bigint REFERENCES users ON DELETE SET NULL,
+    title text NOT NULL,
+    type text[] NOT NULL,
+    energy_kcal double precision NOT NULL DEFAULT 0,
+    protein double precision NOT NULL DEFAULT 0,
+    fat double precision NOT NULL DEFAULT 0,
+    saturated_fat double precision NOT NULL DEFAULT 0,
+    carbohydrates double precision NOT NULL DEFAULT 0,
+    sugar double precision NOT NULL DEFAULT 0,
+    fibre double precision NOT NULL DEFAULT 0,
+    salt double precision NOT NULL DEFAULT 0,
+    serving_size double precision NOT NULL DEFAULT 0,
+    UNIQUE (food_id, version)
+);
+
+-- The older versions of the existing foods are lost, start their history at the current one.
+INSERT INTO food_revisions (food_id, version, created_at, title, type, energy_kcal, protein, fat, saturated_fat, carbohydrates, sugar, fibre, salt, serving_size)
+SELECT id, version, created_at, title, type, energy_kcal, protein, fat, saturated_fat, carbohydrates, sugar, fibre, salt, serving_size
+FROM foods
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+-- The foods in the trash would come back, delete them for good.
+DELETE FROM foods WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS foods_deleted_at_idx;
+ALTER TABLE foods DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,4 @@
+-- The deleted foods stay in the trash until the purger removes them, NULL means not deleted.
+ALTER TABLE foods ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS foods_deleted_at_idx ON foods (deleted_at) WHERE deleted_at IS NOT NULL;
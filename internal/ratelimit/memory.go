@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TokenBucket is the in-memory Limiter, one golang.org/x/time/rate limiter per key. Each
+// instance of the API has its own budgets and they are lost on restart.
+type TokenBucket struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	now     func() time.Time // time.Now, the tests move the clock by hand
+	done    chan struct{}
+	close   sync.Once
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	limit    Limit
+	lastSeen time.Time
+}
+
+// NewTokenBucket creates the limiter and starts a goroutine that forgets the keys not seen
+// in the last 3 minutes, until Close is called.
+func NewTokenBucket() *TokenBucket {
+	tb := &TokenBucket{
+		clients: make(map[string]*client),
+		now:     time.Now,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-tb.done:
+				return
+			case <-ticker.C:
+				tb.sweep(3 * time.Minute)
+			}
+		}
+	}()
+
+	return tb
+}
+
+// Forget the keys not seen for longer than idle.
+func (tb *TokenBucket) sweep(idle time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	for key, client := range tb.clients {
+		if now.Sub(client.lastSeen) > idle {
+			delete(tb.clients, key)
+		}
+	}
+}
+
+// Close stops the goroutine that forgets the old keys.
+func (tb *TokenBucket) Close() {
+	tb.close.Do(func() { close(tb.done) })
+}
+
+func (tb *TokenBucket) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+
+	// A new key, or the limit of the key changed (like a new role of the user).
+	c, found := tb.clients[key]
+	if !found || c.limit != limit {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst), limit: limit}
+		tb.clients[key] = c
+	}
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+	tokens := c.limiter.TokensAt(now)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Reset:     secondsToDuration((float64(limit.Burst) - tokens) / limit.RPS),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / limit.RPS)
+	}
+
+	return result, nil
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 || math.IsInf(s, 0) || math.IsNaN(s) {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
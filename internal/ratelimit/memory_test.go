@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := Limit{RPS: 2, Burst: 4}
+
+	// Every step moves the clock by advance and then asks for one request of the key.
+	tests := []struct {
+		name          string
+		advance       time.Duration
+		key           string
+		limit         Limit
+		wantAllowed   bool
+		wantRemaining int
+		wantReset     time.Duration
+		wantRetry     time.Duration
+	}{
+		{"first of the burst", 0, "a", limit, true, 3, 500 * time.Millisecond, 0},
+		{"second of the burst", 0, "a", limit, true, 2, time.Second, 0},
+		{"third of the burst", 0, "a", limit, true, 1, 1500 * time.Millisecond, 0},
+		{"last of the burst", 0, "a", limit, true, 0, 2 * time.Second, 0},
+		{"burst spent", 0, "a", limit, false, 0, 2 * time.Second, 500 * time.Millisecond},
+		{"another key has its own budget", 0, "b", limit, true, 3, 500 * time.Millisecond, 0},
+		{"half a token refilled", 250 * time.Millisecond, "a", limit, false, 0, 1750 * time.Millisecond, 250 * time.Millisecond},
+		{"one token refilled", 250 * time.Millisecond, "a", limit, true, 0, 2 * time.Second, 0},
+		{"refill stops at the burst", 10 * time.Second, "a", limit, true, 3, 500 * time.Millisecond, 0},
+		{"a new limit starts a new budget", 0, "a", Limit{RPS: 1, Burst: 1}, true, 0, time.Second, 0},
+		{"with the new limit", 0, "a", Limit{RPS: 1, Burst: 1}, false, 0, time.Second, time.Second},
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tb := NewTokenBucket()
+	defer tb.Close()
+	tb.now = func() time.Time { return now }
+
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+
+		got, err := tb.Allow(context.Background(), tt.key, tt.limit)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if got.Allowed != tt.wantAllowed || got.Limit != tt.limit.Burst || got.Remaining != tt.wantRemaining ||
+			!near(got.Reset, tt.wantReset) || !near(got.RetryAfter, tt.wantRetry) {
+			t.Errorf("%s: got %+v, want allowed %v, remaining %d, reset %v, retry after %v",
+				tt.name, got, tt.wantAllowed, tt.wantRemaining, tt.wantReset, tt.wantRetry)
+		}
+	}
+}
+
+// The rate package works with floats, allow for a little rounding.
+func near(got, want time.Duration) bool {
+	diff := got - want
+	return diff > -time.Millisecond && diff < time.Millisecond
+}
+
+func TestTokenBucketSweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tb := NewTokenBucket()
+	defer tb.Close()
+	tb.now = func() time.Time { return now }
+
+	limit := Limit{RPS: 1, Burst: 1}
+	tb.Allow(context.Background(), "old", limit)
+	now = now.Add(2 * time.Minute)
+	tb.Allow(context.Background(), "recent", limit)
+	now = now.Add(2 * time.Minute)
+
+	tb.sweep(3 * time.Minute)
+
+	if _, ok := tb.clients["old"]; ok {
+		t.Error("the key not seen for 4 minutes is still there")
+	}
+	if _, ok := tb.clients["recent"]; !ok {
+		t.Error("the key seen 2 minutes ago was forgotten")
+	}
+
+	// The forgotten key starts with a full budget.
+	got, _ := tb.Allow(context.Background(), "old", limit)
+	if !got.Allowed {
+		t.Errorf("got %+v, want it allowed", got)
+	}
+}
+
+func TestTokenBucketClose(t *testing.T) {
+	tb := NewTokenBucket()
+	tb.Close()
+	tb.Close() // A second Close does nothing
+
+	select {
+	case <-tb.done:
+	default:
+		t.Error("the cleanup goroutine wasn't told to stop")
+	}
+}
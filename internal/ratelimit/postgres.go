@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindow is a Limiter on top of the rate_limits table, so every instance of the API
+// shares the same budgets and they survive restarts. It allows Burst requests per window
+// of Burst/RPS seconds, rounded to whole seconds (4 requests every 2 seconds for 2 rps and
+// a burst of 4).
+//
+// It's the sliding window counter approximation: it counts the requests of fixed windows
+// and weights the previous window by how much of it still overlaps the sliding one.
+type SlidingWindow struct {
+	DB    *sql.DB
+	done  chan struct{}
+	close sync.Once
+}
+
+// NewSlidingWindow creates the limiter and starts a goroutine that deletes the expired
+// windows every minute, until Close is called.
+func NewSlidingWindow(db *sql.DB, onError func(error)) *SlidingWindow {
+	sw := &SlidingWindow{DB: db, done: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sw.done:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE expires_at < now()`)
+			cancel()
+			if err != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	return sw
+}
+
+// Close stops the goroutine that deletes the expired windows.
+func (sw *SlidingWindow) Close() {
+	sw.close.Do(func() { close(sw.done) })
+}
+
+func (sw *SlidingWindow) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	// Whole seconds, so the window starts compare exactly in the database.
+	window := time.Duration(float64(limit.Burst) / limit.RPS * float64(time.Second)).Round(time.Second)
+	if window < time.Second {
+		window = time.Second
+	}
+
+	now := time.Now()
+	start := now.Truncate(window)
+	elapsed := now.Sub(start)
+	// How much of the previous window is still inside the sliding window.
+	weight := 1 - float64(elapsed)/float64(window)
+
+	// Count the request only if it fits, the WHERE of the ON CONFLICT runs with the row
+	// locked so concurrent requests (even from other instances) can't go over the limit.
+	query := `
+	WITH previous AS (
+		SELECT coalesce((SELECT count FROM rate_limits WHERE key = $1 AND window_start = $2), 0) AS count
+	)
+	INSERT INTO rate_limits AS rl (key, window_start, count, expires_at)
+	SELECT $1, $3, 1, $6 FROM previous WHERE 1 + previous.count * $4::float8 <= $5::int
+	ON CONFLICT (key, window_start) DO UPDATE SET count = rl.count + 1
+	WHERE rl.count + 1 + (SELECT count FROM previous) * $4::float8 <= $5::int
+	RETURNING rl.count, (SELECT count FROM previous)`
+
+	args := []interface{}{key, start.Add(-window), start, weight, limit.Burst, start.Add(2 * window)}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var current, previous int
+	allowed := true
+
+	err := sw.DB.QueryRowContext(ctx, query, args...).Scan(&current, &previous)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Result{}, err
+		}
+
+		// Nothing was written, so the request is over the limit. Read the counters for
+		// the headers.
+		allowed = false
+
+		query = `
+		SELECT
+			coalesce((SELECT count FROM rate_limits WHERE key = $1 AND window_start = $3), 0),
+			coalesce((SELECT count FROM rate_limits WHERE key = $1 AND window_start = $2), 0)`
+
+		err = sw.DB.QueryRowContext(ctx, query, key, start.Add(-window), start).Scan(&current, &previous)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	estimated := float64(previous)*weight + float64(current)
+	untilNextWindow := window - elapsed
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: max(limit.Burst-int(math.Ceil(estimated)), 0),
+		Reset:     untilNextWindow,
+	}
+
+	if !allowed {
+		// The previous window keeps fading, the next request fits once enough of it is
+		// out of the sliding window (or when this window ends at the latest).
+		result.RetryAfter = untilNextWindow
+		if previous > 0 && current < limit.Burst {
+			wait := time.Duration((estimated + 1 - float64(limit.Burst)) / float64(previous) * float64(window))
+			result.RetryAfter = min(max(wait, 0), untilNextWindow)
+		}
+	}
+
+	return result, nil
+}
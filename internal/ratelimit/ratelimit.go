@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is the budget of a key: RPS requests per second on average with bursts of up to
+// Burst requests.
+type Limit struct {
+	RPS   float64
+	Burst int
+}
+
+// Result is the decision for one request, with what's needed for the RateLimit-* headers.
+type Result struct {
+	Allowed    bool
+	Limit      int           // Requests allowed in a burst (or in a window)
+	Remaining  int           // Requests left right now
+	Reset      time.Duration // Until the budget is full again
+	RetryAfter time.Duration // Until the next request is allowed, only when !Allowed
+}
+
+// Limiter decides if the request of a key (an IP, a user...) is allowed. TokenBucket keeps
+// the budgets in the memory of the process and SlidingWindow in PostgreSQL, shared by all
+// the instances of the API. Close stops their background cleanup.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	Close()
+}
+
+// Round up to whole seconds, the headers can't have fractions and rounding down would
+// tell the clients to come back too early.
+func Seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}